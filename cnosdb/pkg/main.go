@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	_ "time/tzdata" // Embed the time zone database, Grafana may run on hosts without one.
 
 	"github.com/cnosdb/cnosdb-grafana-datasource-backend/pkg/plugin"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
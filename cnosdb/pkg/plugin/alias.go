@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// aliasPattern matches "$name" and "[[name]]", the latter may contain "-" and "." which are not allowed in the former.
+var aliasPattern = regexp.MustCompile(`\$(\w+)|\[\[([\w.-]+)\]\]`)
+
+// FormatAlias renders the alias of a series, like the alias of the Influx datasource, variables are:
+//   - $table, $m, $measurement: the table name.
+//   - $col: the column name.
+//   - $tag_<key>: the value of the tag <key> of the series.
+//   - $1 to $9: the n-th capture group of groups, see aliasGroups.
+//
+// Unknown variables are kept as they are.
+func FormatAlias(alias string, table string, column string, labels data.Labels, groups []string) string {
+	return aliasPattern.ReplaceAllStringFunc(alias, func(in string) string {
+		name := strings.TrimPrefix(in, "$")
+		name = strings.TrimSuffix(strings.TrimPrefix(name, "[["), "]]")
+
+		switch name {
+		case "table", "m", "measurement":
+			return table
+		case "col":
+			return column
+		}
+		if pos, err := strconv.Atoi(name); err == nil {
+			if len(name) == 1 && pos > 0 && pos < len(groups) {
+				return groups[pos]
+			}
+			return in
+		}
+		if strings.HasPrefix(name, "tag_") {
+			if value, ok := labels[strings.TrimPrefix(name, "tag_")]; ok {
+				return value
+			}
+		}
+		return in
+	})
+}
+
+// aliasRegex is a regex filter of a query, its capture groups are the $<n> variables of aliases.
+type aliasRegex struct {
+	key   string
+	regex *regexp.Regexp
+}
+
+// aliasRegexes returns the regexes of the =~ filters of the query. CnosDB has no regex
+// tables, so the capture groups come from the filters on the tags of the series.
+func (query *QueryModel) aliasRegexes() []aliasRegex {
+	var regexes []aliasRegex
+	for _, tags := range [][]*TagItem{query.Tags, query.AdhocFilters} {
+		for _, tag := range tags {
+			if tag.Operator != "=~" || len(tag.Values) != 1 {
+				continue
+			}
+			// The regex is matched by CnosDB, it is skipped if Go does not support its syntax.
+			if regex, err := regexp.Compile(trimRegex(tag.Values[0])); err == nil {
+				regexes = append(regexes, aliasRegex{key: tag.Key, regex: regex})
+			}
+		}
+	}
+	return regexes
+}
+
+// aliasGroups returns the submatches of the first regex matching the value of its tag in labels,
+// the first submatch is the whole match.
+func aliasGroups(regexes []aliasRegex, labels data.Labels) []string {
+	for _, r := range regexes {
+		value, ok := labels[r.key]
+		if !ok {
+			continue
+		}
+		if groups := r.regex.FindStringSubmatch(value); groups != nil {
+			return groups
+		}
+	}
+	return nil
+}
+
+// applyAlias sets the display name of the value fields in frames.
+func applyAlias(frames data.Frames, query *QueryModel) {
+	if query.Alias == "" {
+		return
+	}
+	regexes := query.aliasRegexes()
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			if field.Type().Time() {
+				continue
+			}
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			field.Config.DisplayNameFromDS = FormatAlias(query.Alias, query.Table, field.Name, field.Labels,
+				aliasGroups(regexes, field.Labels))
+		}
+	}
+}
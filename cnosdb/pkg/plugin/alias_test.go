@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatAlias(t *testing.T) {
+	labels := data.Labels{"host": "server01", "data-center": "dc1"}
+	groups := []string{"server01", "server", "01"}
+
+	assert.Equal(t, "cpu.usage avg", FormatAlias("$table $col", "cpu.usage", "avg", labels, nil))
+	assert.Equal(t, "cpu.usage: server01", FormatAlias("$m: $tag_host", "cpu.usage", "avg", labels, nil))
+	assert.Equal(t, "01 on dc1", FormatAlias("$2 on [[tag_data-center]]", "cpu.usage", "avg", labels, groups))
+	assert.Equal(t, "server avg", FormatAlias("[[1]] [[col]]", "cpu.usage", "avg", labels, groups))
+
+	// Unknown variables are kept.
+	assert.Equal(t, "$tag_region $5 $0 $foo", FormatAlias("$tag_region $5 $0 $foo", "cpu.usage", "avg", labels, groups))
+
+	// Only $1 to $9 are capture groups.
+	many := []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7", "a8", "a9", "a10"}
+	assert.Equal(t, "a9 $10 [[10]] $01", FormatAlias("$9 $10 [[10]] $01", "cpu.usage", "avg", labels, many))
+}
+
+func TestApplyAlias(t *testing.T) {
+	frame := data.NewFrame("response",
+		data.NewField("time", nil, []time.Time{}),
+		data.NewField("value", data.Labels{"host": "a"}, []float64{}),
+	)
+
+	applyAlias(data.Frames{frame}, &QueryModel{Table: "cpu", Alias: "$table.$col{$tag_host}"})
+
+	assert.Nil(t, frame.Fields[0].Config)
+	assert.Equal(t, "cpu.value{a}", frame.Fields[1].Config.DisplayNameFromDS)
+}
+
+func TestApplyAliasCaptureGroups(t *testing.T) {
+	query := &QueryModel{
+		Table: "cpu",
+		Alias: "$1 #$2",
+		Tags: []*TagItem{
+			{Key: "region", Operator: "=~", Values: []string{"/^(eu)-/"}},
+			{Key: "host", Operator: "=~", Values: []string{"/^(web|db)-(\\d+)$/"}},
+		},
+	}
+	frames := data.Frames{
+		data.NewFrame("response", data.NewField("value", data.Labels{"host": "web-12"}, []float64{})),
+		data.NewFrame("response", data.NewField("value", data.Labels{"host": "cache"}, []float64{})),
+	}
+
+	applyAlias(frames, query)
+
+	assert.Equal(t, "web #12", frames[0].Fields[0].Config.DisplayNameFromDS)
+	// Without a match the variables are kept.
+	assert.Equal(t, "$1 #$2", frames[1].Fields[0].Config.DisplayNameFromDS)
+}
@@ -0,0 +1,253 @@
+package plugin
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// cachedResult is a response body of CnosDB kept by resultCache.
+type cachedResult struct {
+	key       string
+	body      []byte
+	fetchedAt time.Time
+}
+
+// cacheFlight is a query being sent to CnosDB, callers of the same key wait for its result.
+// The query runs on its own context, which is canceled once all its callers gave up, so that
+// it is bounded by the longest timeout of its callers rather than by the one which started it.
+type cacheFlight struct {
+	done      chan struct{}
+	cancel    context.CancelFunc
+	waiters   int
+	value     interface{}
+	fetchedAt time.Time
+	err       error
+}
+
+// cacheQuery is a query whose result is kept by resultCache.
+type cacheQuery struct {
+	// run sends the query and returns its decoded result along with its body, the body is
+	// cached unless it is nil.
+	run func(ctx context.Context) (interface{}, []byte, error)
+	// decode decodes a cached body.
+	decode func(body []byte) (interface{}, error)
+	// share copies the result of a query for each of its callers, the result is shared as it
+	// is if share is nil.
+	share func(value interface{}) interface{}
+}
+
+// resultCache is an LRU cache of query results, which expire after ttl. The total size of
+// the cached results is at most maxSize bytes. Concurrent fetches of the same key share a
+// single query.
+type resultCache struct {
+	ttl     time.Duration
+	maxSize int64
+	now     func() time.Time
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	items   map[string]*list.Element
+	flights map[string]*cacheFlight
+}
+
+func newResultCache(ttl time.Duration, maxSize int64) *resultCache {
+	return &resultCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		now:     time.Now,
+		lru:     list.New(),
+		items:   make(map[string]*list.Element),
+		flights: make(map[string]*cacheFlight),
+	}
+}
+
+// cacheKey identifies the result of sql over timeRange.
+func cacheKey(sql string, timeRange backend.TimeRange) string {
+	return fmt.Sprintf("%d:%d:%s", timeRange.From.UnixNano(), timeRange.To.UnixNano(), sql)
+}
+
+// get returns the result of key if it is cached and not expired.
+func (c *resultCache) get(key string) (*cachedResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookup(key)
+}
+
+func (c *resultCache) lookup(key string) (*cachedResult, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	result := elem.Value.(*cachedResult)
+	if c.now().Sub(result.fetchedAt) >= c.ttl {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return result, true
+}
+
+// add caches result, evicting the least recently used results if the cache is full.
+// Results larger than the cache are not cached.
+func (c *resultCache) add(result *cachedResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[result.key]; ok {
+		c.remove(elem)
+	}
+	size := int64(len(result.body))
+	if size > c.maxSize {
+		return
+	}
+	for c.size+size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+	c.items[result.key] = c.lru.PushFront(result)
+	c.size += size
+}
+
+func (c *resultCache) remove(elem *list.Element) {
+	result := c.lru.Remove(elem).(*cachedResult)
+	delete(c.items, result.key)
+	c.size -= int64(len(result.body))
+}
+
+// fetch returns the result of key. A cached body is decoded, otherwise the query is run and its
+// body is cached. Concurrent callers of the same key share a single query. cached is the result
+// the value was made from if it came from the cache or from the query of another caller.
+func (c *resultCache) fetch(ctx context.Context, key string, query cacheQuery) (value interface{}, cached *cachedResult, err error) {
+	c.mu.Lock()
+	if result, ok := c.lookup(key); ok {
+		c.mu.Unlock()
+		if value, err = query.decode(result.body); err != nil {
+			return nil, nil, err
+		}
+		return value, result, nil
+	}
+	flight, shared := c.flights[key]
+	if !shared {
+		flight = c.startFlight(key, query)
+	}
+	flight.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-flight.done:
+		if flight.err != nil {
+			return nil, nil, flight.err
+		}
+		if shared {
+			cached = &cachedResult{key: key, fetchedAt: flight.fetchedAt}
+		}
+		if query.share != nil {
+			return query.share(flight.value), cached, nil
+		}
+		return flight.value, cached, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		flight.waiters--
+		if flight.waiters == 0 {
+			// Nobody waits for the query anymore, later callers start a new one.
+			flight.cancel()
+			if c.flights[key] == flight {
+				delete(c.flights, key)
+			}
+		}
+		c.mu.Unlock()
+		return nil, nil, ctx.Err()
+	}
+}
+
+// startFlight runs query in the background, c.mu must be held.
+func (c *resultCache) startFlight(key string, query cacheQuery) *cacheFlight {
+	ctx, cancel := context.WithCancel(context.Background())
+	flight := &cacheFlight{done: make(chan struct{}), cancel: cancel}
+	c.flights[key] = flight
+	go func() {
+		defer cancel()
+		fetchedAt := c.now()
+		value, body, err := query.run(ctx)
+		if err == nil && body != nil {
+			c.add(&cachedResult{key: key, body: body, fetchedAt: fetchedAt})
+		}
+		c.mu.Lock()
+		if c.flights[key] == flight {
+			delete(c.flights, key)
+		}
+		c.mu.Unlock()
+		flight.value, flight.fetchedAt, flight.err = value, fetchedAt, err
+		close(flight.done)
+	}()
+	return flight
+}
+
+// shareFrame copies a frame shared by several callers, including its notices, since the
+// callers change their frames.
+func shareFrame(value interface{}) interface{} {
+	frame := value.(*data.Frame)
+	res := copyFrame(frame)
+	for i, field := range frame.Fields {
+		res.Fields[i].Config = field.Config
+	}
+	if frame.Meta != nil {
+		meta := *frame.Meta
+		meta.Notices = append([]data.Notice(nil), frame.Meta.Notices...)
+		res.Meta = &meta
+	}
+	return res
+}
+
+// cappedBuffer keeps the bytes written to it as long as there are at most limit of them, so
+// that a response can be cached while it is decoded.
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if !b.overflow && int64(b.buf.Len()+len(p)) <= b.limit {
+		b.buf.Write(p)
+	} else if !b.overflow {
+		b.overflow = true
+		b.buf = bytes.Buffer{}
+	}
+	return len(p), nil
+}
+
+// Bytes returns the bytes written, it is nil if there were more than limit of them.
+func (b *cappedBuffer) Bytes() []byte {
+	if b.overflow {
+		return nil
+	}
+	return b.buf.Bytes()
+}
+
+// markCached tells in the frames that they were made from a cached result.
+func markCached(frames data.Frames, result *cachedResult, now time.Time) {
+	age := now.Sub(result.fetchedAt).Truncate(time.Second)
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		custom, ok := frame.Meta.Custom.(map[string]interface{})
+		if !ok {
+			custom = make(map[string]interface{})
+		}
+		custom["cached"] = true
+		frame.Meta.Custom = custom
+		frame.AppendNotices(data.Notice{
+			Text:     fmt.Sprintf("Served from cache, fetched %s ago", age),
+			Severity: data.NoticeSeverityInfo,
+		})
+	}
+}
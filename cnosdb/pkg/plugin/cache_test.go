@@ -0,0 +1,209 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultCacheEviction(t *testing.T) {
+	cache := newResultCache(time.Minute, 10)
+	now := time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	cache.add(&cachedResult{key: "a", body: []byte("1234"), fetchedAt: now})
+	cache.add(&cachedResult{key: "b", body: []byte("1234"), fetchedAt: now})
+	_, ok := cache.get("a")
+	require.True(t, ok)
+
+	// "b" is the least recently used.
+	cache.add(&cachedResult{key: "c", body: []byte("1234"), fetchedAt: now})
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	_, ok = cache.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), cache.size)
+
+	// Results larger than the cache are not cached.
+	cache.add(&cachedResult{key: "d", body: []byte("12345678901"), fetchedAt: now})
+	_, ok = cache.get("d")
+	assert.False(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = cache.get("a")
+	assert.False(t, ok)
+	assert.Equal(t, int64(4), cache.size)
+}
+
+// stringQuery is a cacheQuery whose result is its body as a string.
+func stringQuery(run func(ctx context.Context) (string, error)) cacheQuery {
+	return cacheQuery{
+		run: func(ctx context.Context) (interface{}, []byte, error) {
+			value, err := run(ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+			return value, []byte(value), nil
+		},
+		decode: func(body []byte) (interface{}, error) {
+			return string(body), nil
+		},
+	}
+}
+
+func TestResultCacheFetch(t *testing.T) {
+	cache := newResultCache(time.Minute, 1<<10)
+
+	var calls int32
+	release := make(chan struct{})
+	query := stringQuery(func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "result", nil
+	})
+
+	var wg sync.WaitGroup
+	var hits int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, cached, err := cache.fetch(context.Background(), "k", query)
+			assert.NoError(t, err)
+			assert.Equal(t, "result", value)
+			if cached != nil {
+				atomic.AddInt32(&hits, 1)
+			}
+		}()
+	}
+	// Wait until the other callers wait for the first one.
+	for {
+		cache.mu.Lock()
+		n := 0
+		if flight, ok := cache.flights["k"]; ok {
+			n = flight.waiters
+		}
+		cache.mu.Unlock()
+		if n == 5 && atomic.LoadInt32(&calls) == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls)
+	assert.Equal(t, int32(4), hits)
+
+	value, cached, err := cache.fetch(context.Background(), "k", query)
+	require.NoError(t, err)
+	assert.NotNil(t, cached)
+	assert.Equal(t, "result", value)
+	assert.Equal(t, int32(1), calls)
+
+	// Errors are not cached.
+	_, _, err = cache.fetch(context.Background(), "e", stringQuery(func(ctx context.Context) (string, error) {
+		return "", errors.New("failed")
+	}))
+	assert.EqualError(t, err, "failed")
+	_, ok := cache.get("e")
+	assert.False(t, ok)
+}
+
+func TestResultCacheFetchCanceled(t *testing.T) {
+	cache := newResultCache(time.Minute, 1<<10)
+
+	release := make(chan struct{})
+	canceled := make(chan struct{})
+	query := stringQuery(func(ctx context.Context) (string, error) {
+		select {
+		case <-release:
+			return "result", nil
+		case <-ctx.Done():
+			close(canceled)
+			return "", ctx.Err()
+		}
+	})
+
+	// The caller which started the query gives up, the query goes on for the other one.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, _, err := cache.fetch(ctx, "k", query)
+		done <- err
+	}()
+	for {
+		cache.mu.Lock()
+		_, ok := cache.flights["k"]
+		cache.mu.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	results := make(chan interface{})
+	go func() {
+		value, _, err := cache.fetch(context.Background(), "k", query)
+		assert.NoError(t, err)
+		results <- value
+	}()
+	for {
+		cache.mu.Lock()
+		n := cache.flights["k"].waiters
+		cache.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	close(release)
+	assert.Equal(t, "result", <-results)
+
+	// The query is canceled once all its callers gave up.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err := cache.fetch(ctx, "other", stringQuery(func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		close(canceled)
+		return "", ctx.Err()
+	}))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	<-canceled
+}
+
+func TestCappedBuffer(t *testing.T) {
+	buf := &cappedBuffer{limit: 4}
+	_, _ = buf.Write([]byte("ab"))
+	_, _ = buf.Write([]byte("cd"))
+	assert.Equal(t, "abcd", string(buf.Bytes()))
+	_, _ = buf.Write([]byte("e"))
+	assert.Nil(t, buf.Bytes())
+}
+
+func TestAlignTimeRange(t *testing.T) {
+	query := &QueryModel{Interval: "10 minutes", Location: time.UTC}
+	dataQuery := &backend.DataQuery{TimeRange: backend.TimeRange{
+		From: time.Date(2022, 10, 10, 0, 7, 30, 0, time.UTC),
+		To:   time.Date(2022, 10, 10, 6, 7, 30, 0, time.UTC),
+	}}
+	assert.Equal(t, backend.TimeRange{
+		From: time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2022, 10, 10, 6, 10, 0, 0, time.UTC),
+	}, query.alignTimeRange(dataQuery))
+
+	// Buckets start at midnight in the time zone of the query.
+	loc := time.FixedZone("UTC+5:30", 5*3600+1800)
+	query = &QueryModel{Interval: "1 hours", Location: loc}
+	aligned := query.alignTimeRange(dataQuery)
+	assert.True(t, time.Date(2022, 10, 9, 23, 30, 0, 0, time.UTC).Equal(aligned.From))
+	assert.True(t, time.Date(2022, 10, 10, 6, 30, 0, 0, time.UTC).Equal(aligned.To))
+}
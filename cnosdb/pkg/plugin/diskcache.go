@@ -0,0 +1,281 @@
+package plugin
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// DISK_CACHE_CHUNK is the time range of the chunks of query results kept by the disk cache,
+// rounded up to a multiple of the interval of the query.
+const DISK_CACHE_CHUNK = 24 * time.Hour
+
+// DISK_CACHE_ROOT_ENV is the environment variable of the root directory of the disk caches, the
+// directories of the datasources are in it. It is a directory of the plugin in the temporary
+// directory of the system by default.
+const DISK_CACHE_ROOT_ENV = "CNOSDB_PLUGIN_CACHE_DIR"
+
+const diskCacheExt = ".arrow"
+
+var (
+	// Names of the files of the disk cache, other files are never touched.
+	diskCacheFilePattern = regexp.MustCompile(`^[0-9a-f]{64}\.arrow$`)
+	diskCacheTempPattern = regexp.MustCompile(`^[0-9a-f]{64}\.[0-9]+\.tmp$`)
+	// Datasource UIDs which are used as directory names as they are.
+	diskCacheUIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// validateDiskCacheDir checks that dir, the disk cache directory of a datasource, is a relative
+// path which stays in the root directory of the disk caches.
+func validateDiskCacheDir(dir string) error {
+	clean := filepath.Clean(dir)
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || strings.HasPrefix(clean, string(filepath.Separator)) {
+		return fmt.Errorf("%q must be relative to the directory set by %s", dir, DISK_CACHE_ROOT_ENV)
+	}
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%q must not be outside of the directory set by %s", dir, DISK_CACHE_ROOT_ENV)
+	}
+	return nil
+}
+
+// diskCacheDir returns the directory of the disk cache of a datasource configured with dir, which
+// is checked by validateDiskCacheDir. The files are in a subdirectory named by the UID of the
+// datasource, or by a hash of its URL and database if it has no UID, so that datasources sharing
+// dir do not evict the files of each other.
+func diskCacheDir(dir string, uid string, url string, database string) string {
+	root := os.Getenv(DISK_CACHE_ROOT_ENV)
+	if root == "" {
+		root = filepath.Join(os.TempDir(), "cnosdb-grafana-datasource")
+	}
+	name := uid
+	if !diskCacheUIDPattern.MatchString(name) {
+		name = diskCacheKey(url, database)
+	}
+	return filepath.Join(root, filepath.Clean(dir), name)
+}
+
+// diskCache keeps frames in the files of dir, encoded with Arrow, dir belongs to a single
+// datasource. The least recently used files are removed when their total size exceeds maxSize.
+// The files are used again after the plugin restarts, the modification time of a file is the
+// time it was last used.
+type diskCache struct {
+	dir     string
+	maxSize int64
+	now     func() time.Time
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type diskEntry struct {
+	key  string
+	size int64
+}
+
+func newDiskCache(dir string, maxSize int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	c := &diskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		now:     time.Now,
+		lru:     list.New(),
+		items:   make(map[string]*list.Element),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if diskCacheTempPattern.MatchString(file.Name()) {
+			// Left by a write which did not finish.
+			_ = os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+		if !diskCacheFilePattern.MatchString(file.Name()) {
+			continue
+		}
+		if info, err := file.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		key := strings.TrimSuffix(info.Name(), diskCacheExt)
+		c.items[key] = c.lru.PushFront(&diskEntry{key: key, size: info.Size()})
+		c.size += info.Size()
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// diskCacheKey returns the name of the file of a result, parts identify the result.
+func diskCacheKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *diskCache) path(key string) string {
+	return filepath.Join(c.dir, key+diskCacheExt)
+}
+
+// get returns the frame of key if it is in the cache.
+func (c *diskCache) get(key string) (*data.Frame, bool) {
+	c.mu.Lock()
+	elem, ok := c.items[key]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	path := c.path(key)
+	b, err := os.ReadFile(path)
+	if err == nil {
+		var frame *data.Frame
+		if frame, err = data.UnmarshalArrowFrame(b); err == nil {
+			now := c.now()
+			_ = os.Chtimes(path, now, now)
+			return frame, true
+		}
+	}
+
+	// The file was removed or it is corrupted.
+	c.mu.Lock()
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+	c.mu.Unlock()
+	return nil, false
+}
+
+// put writes the frame of key, which is returned by diskCacheKey, to the cache. Frames larger
+// than the cache are not written.
+func (c *diskCache) put(key string, frame *data.Frame) error {
+	b, err := frame.MarshalArrow()
+	if err != nil {
+		return err
+	}
+	size := int64(len(b))
+	if size > c.maxSize {
+		return nil
+	}
+
+	// The file is renamed once it is written, so that a file of the cache is never partially written.
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Close()
+	} else {
+		_ = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.size -= elem.Value.(*diskEntry).size
+		c.lru.Remove(elem)
+	}
+	c.items[key] = c.lru.PushFront(&diskEntry{key: key, size: size})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+func (c *diskCache) evict() {
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *diskCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*diskEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+	_ = os.Remove(c.path(entry.key))
+}
+
+// chunkRanges splits the time range of dataQuery into chunks of DISK_CACHE_CHUNK which end before
+// immutableBefore, the time range of the last chunk may end after the time range of the query.
+// It also returns the start of the rest of the time range, which is not in the chunks. No chunks
+// are returned if their time buckets do not have the same origin as the buckets of the query.
+func (query *QueryModel) chunkRanges(dataQuery *backend.DataQuery, immutableBefore time.Time) ([]backend.TimeRange, time.Time) {
+	interval := query.bucketInterval(dataQuery)
+	chunk := (DISK_CACHE_CHUNK + interval - 1) / interval * interval
+	origin := query.Origin(dataQuery.TimeRange)
+	immutableBefore = alignTime(immutableBefore, origin, chunk)
+
+	var chunks []backend.TimeRange
+	start := alignTime(dataQuery.TimeRange.From, origin, chunk)
+	for ; start.Before(immutableBefore) && !start.After(dataQuery.TimeRange.To); start = start.Add(chunk) {
+		timeRange := backend.TimeRange{From: start, To: start.Add(chunk - time.Nanosecond)}
+		if !query.Origin(timeRange).Equal(origin) {
+			return nil, dataQuery.TimeRange.From
+		}
+		chunks = append(chunks, timeRange)
+	}
+	return chunks, start
+}
+
+// concatFrames concatenates the rows of frames in the time range, up to limit rows.
+// It fails if the frames do not have the same fields.
+func concatFrames(frames []*data.Frame, timeRange backend.TimeRange, limit int) (*data.Frame, bool) {
+	var res *data.Frame
+	for _, frame := range frames {
+		if frame.Rows() == 0 {
+			continue
+		}
+		if res == nil {
+			if len(frame.Fields) == 0 || !frame.Fields[0].Type().Time() {
+				return nil, false
+			}
+			res = frame.EmptyCopy()
+		} else if !sameFields(res, frame) {
+			return nil, false
+		}
+		for i := 0; i < frame.Rows() && res.Rows() < limit; i++ {
+			t, ok := frame.Fields[0].ConcreteAt(i)
+			if ok && !t.(time.Time).Before(timeRange.From) && !t.(time.Time).After(timeRange.To) {
+				res.AppendRow(frame.RowCopy(i)...)
+			}
+		}
+	}
+	if res == nil {
+		return frames[0].EmptyCopy(), true
+	}
+	return res, true
+}
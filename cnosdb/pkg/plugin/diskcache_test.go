@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newChunkFrame(values ...float64) *data.Frame {
+	times := make([]time.Time, len(values))
+	for i := range values {
+		times[i] = time.Date(2022, 10, 10, i, 0, 0, 0, time.UTC)
+	}
+	return data.NewFrame("response", data.NewField("time", nil, times), data.NewField("v", nil, values))
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	frame := newChunkFrame(1, 2, 3)
+	b, err := frame.MarshalArrow()
+	require.NoError(t, err)
+	size := int64(len(b))
+
+	cache, err := newDiskCache(dir, 2*size)
+	require.NoError(t, err)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	a, b2, c, d, e := diskCacheKey("a"), diskCacheKey("b"), diskCacheKey("c"), diskCacheKey("d"), diskCacheKey("e")
+	require.NoError(t, cache.put(a, frame))
+	require.NoError(t, cache.put(b2, frame))
+	got, ok := cache.get(a)
+	require.True(t, ok)
+	assert.Equal(t, 3, got.Rows())
+	assert.Equal(t, 2.0, got.Fields[1].At(1))
+
+	// "b" is the least recently used.
+	require.NoError(t, cache.put(c, frame))
+	_, ok = cache.get(b2)
+	assert.False(t, ok)
+	_, err = os.Stat(cache.path(b2))
+	assert.True(t, os.IsNotExist(err))
+
+	// The files are found again after a restart, in the order they were used. Files which
+	// are not named like the files of the cache are left as they are.
+	now = now.Add(time.Minute)
+	_, ok = cache.get(a)
+	require.True(t, ok)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, d+".123.tmp"), []byte("partial"), 0o600))
+	for _, name := range []string{"notes.tmp", "other.arrow"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), b, 0o600))
+	}
+
+	cache, err = newDiskCache(dir, 2*size)
+	require.NoError(t, err)
+	require.NoError(t, cache.put(e, frame))
+	_, ok = cache.get(c)
+	assert.False(t, ok)
+	_, ok = cache.get(a)
+	assert.True(t, ok)
+	_, err = os.Stat(filepath.Join(dir, d+".123.tmp"))
+	assert.True(t, os.IsNotExist(err))
+	for _, name := range []string{"notes.tmp", "other.arrow"} {
+		_, err = os.Stat(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+	}
+
+	// Corrupted files are dropped.
+	require.NoError(t, os.WriteFile(cache.path(a), []byte("corrupted"), 0o600))
+	_, ok = cache.get(a)
+	assert.False(t, ok)
+}
+
+func TestDiskCacheDir(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Setenv(DISK_CACHE_ROOT_ENV, root))
+	defer os.Unsetenv(DISK_CACHE_ROOT_ENV)
+
+	assert.Equal(t, filepath.Join(root, "cnosdb", "P1A2B3"), diskCacheDir("cnosdb", "P1A2B3", "http://a", "db"))
+	assert.Equal(t, filepath.Join(root, "a", "b", "P1A2B3"), diskCacheDir("a/./b/", "P1A2B3", "http://a", "db"))
+	// Datasources without a usable UID are told apart by their URL and database.
+	dir := diskCacheDir("cnosdb", "", "http://a", "db")
+	assert.Equal(t, filepath.Join(root, "cnosdb"), filepath.Dir(dir))
+	assert.NotEqual(t, dir, diskCacheDir("cnosdb", "", "http://a", "other"))
+	assert.Equal(t, filepath.Join(root, "cnosdb"), filepath.Dir(diskCacheDir("cnosdb", "../x", "http://a", "db")))
+
+	for _, dir := range []string{"cnosdb", "a/b", "a/../b", "."} {
+		assert.NoError(t, validateDiskCacheDir(dir), dir)
+	}
+	for _, dir := range []string{"/var/lib/grafana", "..", "../x", "a/../../x"} {
+		assert.Error(t, validateDiskCacheDir(dir), dir)
+	}
+}
+
+func TestChunkRanges(t *testing.T) {
+	query := &QueryModel{Interval: "5 hours", Location: time.UTC}
+	from := time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC)
+	dataQuery := &backend.DataQuery{TimeRange: backend.TimeRange{From: from.Add(30 * time.Hour), To: from.Add(100 * time.Hour)}}
+
+	// Chunks are 25 hours, the multiple of 5 hours above a day, starting at the origin of buckets.
+	chunk := 25 * time.Hour
+	start := alignTime(dataQuery.TimeRange.From, time.Unix(0, 0).UTC(), chunk)
+	chunks, rest := query.chunkRanges(dataQuery, from.Add(90*time.Hour))
+	require.Len(t, chunks, 2)
+	assert.True(t, chunks[0].From.Equal(start))
+	assert.True(t, chunks[1].To.Equal(start.Add(2*chunk-time.Nanosecond)))
+	assert.True(t, rest.Equal(start.Add(2*chunk)))
+
+	chunks, _ = query.chunkRanges(dataQuery, from.Add(40*time.Hour))
+	assert.Empty(t, chunks)
+}
+
+func TestConcatFrames(t *testing.T) {
+	timeRange := backend.TimeRange{
+		From: time.Date(2022, 10, 10, 1, 0, 0, 0, time.UTC),
+		To:   time.Date(2022, 10, 10, 5, 0, 0, 0, time.UTC),
+	}
+	empty := newChunkFrame()
+	frame, ok := concatFrames([]*data.Frame{newChunkFrame(1, 2, 3), empty, newChunkFrame(4, 5, 6, 7, 8, 9, 10)}, timeRange, 3)
+	require.True(t, ok)
+	assert.Equal(t, 3, frame.Rows())
+	assert.Equal(t, 2.0, frame.Fields[1].At(0))
+
+	_, ok = concatFrames([]*data.Frame{newChunkFrame(1), data.NewFrame("response", data.NewField("time", nil, []time.Time{}))},
+		timeRange, 10)
+	assert.True(t, ok)
+	other := data.NewFrame("response", data.NewField("time", nil, []time.Time{timeRange.From}), data.NewField("w", nil, []float64{1}))
+	_, ok = concatFrames([]*data.Frame{newChunkFrame(1, 2), other}, timeRange, 10)
+	assert.False(t, ok)
+}
@@ -0,0 +1,208 @@
+package plugin
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryWindow is the result of a query over a rolling time window, it is kept so that the
+// next refresh only fetches the rows of the buckets which were not closed yet.
+type queryWindow struct {
+	key string
+	// frame is the decoded response of CnosDB, before it is split into series.
+	frame *data.Frame
+	// timeRange is the aligned time range of frame.
+	timeRange backend.TimeRange
+	// closed is the end of the buckets which were closed when frame was fetched, their rows do not change anymore.
+	closed time.Time
+	// size is the estimated bytes of frame.
+	size int64
+	// storedAt is when the window was put in the store.
+	storedAt time.Time
+}
+
+// windowStore keeps the windows of the maxEntries most recently used queries, their total
+// size is at most maxSize bytes and they expire after ttl.
+type windowStore struct {
+	maxEntries int
+	maxSize    int64
+	ttl        time.Duration
+	// grace is how long after its end a bucket may still receive rows.
+	grace time.Duration
+	now   func() time.Time
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+func newWindowStore(maxEntries int, maxSize int64, ttl time.Duration, grace time.Duration) *windowStore {
+	return &windowStore{
+		maxEntries: maxEntries,
+		maxSize:    maxSize,
+		ttl:        ttl,
+		grace:      grace,
+		now:        time.Now,
+		lru:        list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// closedBefore returns the time before which buckets are closed, rows arriving late are
+// expected for the grace period.
+func (s *windowStore) closedBefore() time.Time {
+	return s.now().Add(-s.grace)
+}
+
+func (s *windowStore) get(key string) (*queryWindow, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	window := elem.Value.(*queryWindow)
+	if s.now().Sub(window.storedAt) >= s.ttl {
+		s.remove(elem)
+		return nil, false
+	}
+	s.lru.MoveToFront(elem)
+	return window, true
+}
+
+// put keeps window, evicting the least recently used windows if the store is full. Windows
+// larger than the store are not kept.
+func (s *windowStore) put(window *queryWindow) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.items[window.key]; ok {
+		s.remove(elem)
+	}
+	window.size = frameSize(window.frame)
+	window.storedAt = s.now()
+	if window.size > s.maxSize {
+		return
+	}
+	for s.lru.Len() >= s.maxEntries || s.size+window.size > s.maxSize {
+		s.remove(s.lru.Back())
+	}
+	s.items[window.key] = s.lru.PushFront(window)
+	s.size += window.size
+}
+
+func (s *windowStore) remove(elem *list.Element) {
+	window := s.lru.Remove(elem).(*queryWindow)
+	delete(s.items, window.key)
+	s.size -= window.size
+}
+
+// frameSize estimates the bytes of the values of frame: 8 bytes per value, and the length of strings.
+func frameSize(frame *data.Frame) int64 {
+	var size int64
+	for _, field := range frame.Fields {
+		size += int64(field.Len()) * 8
+		if field.Type() != data.FieldTypeString && field.Type() != data.FieldTypeNullableString {
+			continue
+		}
+		for i := 0; i < field.Len(); i++ {
+			if v, ok := field.ConcreteAt(i); ok {
+				size += int64(len(v.(string)))
+			}
+		}
+	}
+	return size
+}
+
+// windowKey identifies the windows of a query, a query whose model or interval changed
+// does not find the window of its previous version and is fetched in full.
+func (query *QueryModel) windowKey(dataQuery *backend.DataQuery) string {
+	model, err := json.Marshal(query)
+	if err != nil {
+		return ""
+	}
+	return query.bucketInterval(dataQuery).String() + ":" + dataQuery.Interval.String() + ":" + string(model)
+}
+
+// resumableFrom returns the start of the rows to fetch for timeRange if the rows before it
+// are in the window, which is the end of its closed buckets.
+func (query *QueryModel) resumableFrom(window *queryWindow, timeRange backend.TimeRange) (time.Time, bool) {
+	if timeRange.From.Before(window.timeRange.From) || !window.closed.After(timeRange.From) || window.closed.After(timeRange.To) {
+		return time.Time{}, false
+	}
+	// Buckets of both ranges must have the same origin, it changes with the UTC offset of the time zone.
+	if !query.Origin(timeRange).Equal(query.Origin(backend.TimeRange{From: window.closed, To: timeRange.To})) {
+		return time.Time{}, false
+	}
+	return window.closed, true
+}
+
+// newWindow returns the window of frame fetched over timeRange, whose buckets ending before
+// closedBefore are closed. It returns nil if frame cannot be kept: it is truncated or it has
+// no time field.
+func (query *QueryModel) newWindow(key string, frame *data.Frame, dataQuery *backend.DataQuery, closedBefore time.Time) *queryWindow {
+	if key == "" || frame.Rows() >= query.limit() || frame.Meta != nil && len(frame.Meta.Notices) > 0 {
+		return nil
+	}
+	if len(frame.Fields) == 0 || !frame.Fields[0].Type().Time() {
+		return nil
+	}
+	closed := dataQuery.TimeRange.To
+	if closedBefore.Before(closed) {
+		closed = closedBefore
+	}
+	return &queryWindow{
+		key:       key,
+		frame:     frame,
+		timeRange: dataQuery.TimeRange,
+		closed:    alignTime(closed, query.Origin(dataQuery.TimeRange), query.bucketInterval(dataQuery)),
+	}
+}
+
+// merge returns the rows of the window in the closed buckets from the start of timeRange,
+// followed by the rows of frame, which are the rows fetched since the closed buckets.
+// The result has at most limit rows, as the result of the query over timeRange.
+func (window *queryWindow) merge(frame *data.Frame, timeRange backend.TimeRange, limit int) (*data.Frame, bool) {
+	if frame.Rows() > 0 && !sameFields(window.frame, frame) {
+		return nil, false
+	}
+	merged := window.frame.EmptyCopy()
+	timeField := window.frame.Fields[0]
+	for i := 0; i < window.frame.Rows() && merged.Rows() < limit; i++ {
+		t, ok := timeField.ConcreteAt(i)
+		if ok && !t.(time.Time).Before(timeRange.From) && t.(time.Time).Before(window.closed) {
+			merged.AppendRow(window.frame.RowCopy(i)...)
+		}
+	}
+	for i := 0; i < frame.Rows() && merged.Rows() < limit; i++ {
+		merged.AppendRow(frame.RowCopy(i)...)
+	}
+	return merged, true
+}
+
+// sameFields tells if frames have fields of the same names and types.
+func sameFields(a *data.Frame, b *data.Frame) bool {
+	if len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i, field := range a.Fields {
+		if field.Name != b.Fields[i].Name || field.Type() != b.Fields[i].Type() {
+			return false
+		}
+	}
+	return true
+}
+
+// copyFrame copies the fields and rows of frame, so that the copy can be changed without changing frame.
+func copyFrame(frame *data.Frame) *data.Frame {
+	res := frame.EmptyCopy()
+	for i := 0; i < frame.Rows(); i++ {
+		res.AppendRow(frame.RowCopy(i)...)
+	}
+	return res
+}
@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWindow(key string, rows int) *queryWindow {
+	values := make([]float64, rows)
+	return &queryWindow{key: key, frame: data.NewFrame("", data.NewField("v", nil, values))}
+}
+
+func TestWindowStore(t *testing.T) {
+	now := time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC)
+	store := newWindowStore(2, 100, time.Minute, 0)
+	store.now = func() time.Time { return now }
+
+	// Windows are evicted when there are too many of them or they are too large.
+	store.put(newTestWindow("a", 4))
+	store.put(newTestWindow("b", 4))
+	store.put(newTestWindow("c", 4))
+	_, ok := store.get("a")
+	assert.False(t, ok)
+	store.put(newTestWindow("d", 8))
+	_, ok = store.get("b")
+	assert.False(t, ok)
+	_, ok = store.get("d")
+	assert.True(t, ok)
+	assert.Equal(t, int64(96), store.size)
+	store.put(newTestWindow("e", 20))
+	_, ok = store.get("e")
+	assert.False(t, ok)
+
+	// Windows expire after the TTL.
+	now = now.Add(time.Minute)
+	_, ok = store.get("c")
+	assert.False(t, ok)
+	_, ok = store.get("d")
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), store.size)
+}
+
+func TestNewWindowGracePeriod(t *testing.T) {
+	now := time.Date(2022, 10, 10, 1, 0, 30, 0, time.UTC)
+	store := newWindowStore(1, 1<<20, time.Minute, time.Minute)
+	store.now = func() time.Time { return now }
+
+	query := &QueryModel{Interval: "1 minute"}
+	dataQuery := &backend.DataQuery{TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now}}
+	frame := data.NewFrame("", data.NewField("time", nil, []time.Time{now}))
+	window := query.newWindow("k", frame, dataQuery, store.closedBefore())
+	require.NotNil(t, window)
+	// The bucket of 00:59 may still receive rows.
+	assert.True(t, time.Date(2022, 10, 10, 0, 59, 0, 0, time.UTC).Equal(window.closed), window.closed)
+}
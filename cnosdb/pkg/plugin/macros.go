@@ -0,0 +1,289 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// macroPattern matches a macro or a variable at the start of the text, such as "$__timeFilter"
+// or "$timeFilter". Macros taking arguments are followed by "(".
+var macroPattern = regexp.MustCompile(`^\$(__)?(\w+)`)
+
+type macroRenderer func(query *QueryModel, dataQuery *backend.DataQuery, args []string) (string, error)
+
+var macros = map[string]macroRenderer{
+	"timeFilter":        timeFilterMacro,
+	"timeFrom":          timeFromMacro,
+	"timeTo":            timeToMacro,
+	"unixEpochFilter":   unixEpochFilterMacro,
+	"timeGroup":         timeGroupMacro,
+	"timeGroupAlias":    timeGroupAliasMacro,
+	"unixEpochFrom":     unixEpochFromMacro,
+	"unixEpochTo":       unixEpochToMacro,
+	"unixEpochNanoFrom": unixEpochNanoFromMacro,
+	"unixEpochNanoTo":   unixEpochNanoToMacro,
+}
+
+// interpolateMacros replaces $timeFilter and the Grafana macros in sql:
+//   - $__timeFilter(col): col is in the time range of the query.
+//   - $__timeFrom(), $__timeTo(): start and end of the time range as timestamps.
+//   - $__unixEpochFilter(col): col, in seconds since the Unix epoch, is in the time range of the query.
+//   - $__unixEpochFrom(), $__unixEpochTo(), $__unixEpochNanoFrom(), $__unixEpochNanoTo(): start and
+//     end of the time range in seconds or nanoseconds since the Unix epoch.
+//   - $__timeGroup(col, interval[, fill]): col rounded down to the time bucket of interval, missing
+//     buckets are filled with fill, which is NULL, previous or a number.
+//   - $__timeGroupAlias(col, interval[, fill]): $__timeGroup aliased as time.
+//   - $__interval_ms, $__interval: the interval of the query, in milliseconds or as an interval string.
+//
+// String literals, quoted identifiers and comments are left as they are.
+func (query *QueryModel) interpolateMacros(sql string, dataQuery *backend.DataQuery) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(sql); {
+		if end := skipQuoted(sql, i); end > i {
+			sb.WriteString(sql[i:end])
+			i = end
+			continue
+		}
+		var loc []int
+		if sql[i] == '$' {
+			loc = macroPattern.FindStringSubmatchIndex(sql[i:])
+		}
+		if loc == nil {
+			sb.WriteByte(sql[i])
+			i++
+			continue
+		}
+		end := i + loc[1]
+		name := sql[i+loc[4] : i+loc[5]]
+		withArgs := end < len(sql) && sql[end] == '('
+		if loc[2] < 0 {
+			// Only $timeFilter is replaced among the variables without "__".
+			if name == "timeFilter" {
+				sb.WriteString(timeFilterExpr(dataQuery.TimeRange).String())
+			} else {
+				sb.WriteString(sql[i:end])
+			}
+			i = end
+			continue
+		}
+
+		macro, ok := macros[name]
+		switch {
+		case ok && withArgs:
+			args, argsEnd, err := parseMacroArgs(sql, end+1)
+			if err != nil {
+				return "", fmt.Errorf("failed to parse macro $__%s: %s", name, err)
+			}
+			rendered, err := macro(query, dataQuery, args)
+			if err != nil {
+				return "", fmt.Errorf("failed to parse macro $__%s: %s", name, err)
+			}
+			sb.WriteString(rendered)
+			end = argsEnd
+		case ok:
+			return "", fmt.Errorf("failed to parse macro $__%s: missing arguments", name)
+		case name == "interval_ms":
+			sb.WriteString(strconv.FormatInt(query.autoInterval(dataQuery).Milliseconds(), 10))
+		case name == "interval":
+			sb.WriteString(FormatIntervalString(query.autoInterval(dataQuery)))
+		case withArgs:
+			return "", fmt.Errorf("unknown macro $__%s", name)
+		default:
+			sb.WriteString(sql[i:end])
+		}
+		i = end
+	}
+	return sb.String(), nil
+}
+
+// skipQuoted returns the end of the string literal, quoted identifier or comment starting at
+// start of sql, or start if there is none. Unterminated ones end at the end of sql.
+func skipQuoted(sql string, start int) int {
+	var end int
+	switch {
+	case sql[start] == '\'' || sql[start] == '"':
+		// Doubled quotes inside are two consecutive quoted spans.
+		end = strings.IndexByte(sql[start+1:], sql[start])
+		if end >= 0 {
+			return start + 1 + end + 1
+		}
+	case strings.HasPrefix(sql[start:], "--"):
+		end = strings.IndexByte(sql[start:], '\n')
+		if end >= 0 {
+			return start + end + 1
+		}
+	case strings.HasPrefix(sql[start:], "/*"):
+		end = strings.Index(sql[start+2:], "*/")
+		if end >= 0 {
+			return start + 2 + end + 2
+		}
+	default:
+		return start
+	}
+	return len(sql)
+}
+
+// parseMacroArgs parses the comma separated arguments of a macro, start is the position
+// after the opening parenthesis. It returns the position after the closing parenthesis.
+func parseMacroArgs(sql string, start int) ([]string, int, error) {
+	var args []string
+	depth := 0
+	quote := byte(0)
+	argStart := start
+	for i := start; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ')':
+			arg := strings.TrimSpace(sql[argStart:i])
+			if arg != "" || len(args) > 0 {
+				args = append(args, arg)
+			}
+			return args, i + 1, nil
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(sql[argStart:i]))
+			argStart = i + 1
+		}
+	}
+	return nil, 0, fmt.Errorf("missing closing parenthesis")
+}
+
+func checkMacroArgs(args []string, min int, max int) error {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return fmt.Errorf("expected %d arguments, got %d", min, len(args))
+		}
+		return fmt.Errorf("expected %d to %d arguments, got %d", min, max, len(args))
+	}
+	for _, arg := range args {
+		if arg == "" {
+			return fmt.Errorf("empty argument")
+		}
+	}
+	return nil
+}
+
+func renderTimestamp(t time.Time) string {
+	return fmt.Sprintf("TIMESTAMP '%s'", t.UTC().Format(time.RFC3339Nano))
+}
+
+func timeFilterMacro(query *QueryModel, dataQuery *backend.DataQuery, args []string) (string, error) {
+	if err := checkMacroArgs(args, 1, 1); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s >= %s AND %s <= %s", args[0], renderTimestamp(dataQuery.TimeRange.From),
+		args[0], renderTimestamp(dataQuery.TimeRange.To)), nil
+}
+
+func timeFromMacro(query *QueryModel, dataQuery *backend.DataQuery, args []string) (string, error) {
+	if err := checkMacroArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	return renderTimestamp(dataQuery.TimeRange.From), nil
+}
+
+func timeToMacro(query *QueryModel, dataQuery *backend.DataQuery, args []string) (string, error) {
+	if err := checkMacroArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	return renderTimestamp(dataQuery.TimeRange.To), nil
+}
+
+func unixEpochFilterMacro(query *QueryModel, dataQuery *backend.DataQuery, args []string) (string, error) {
+	if err := checkMacroArgs(args, 1, 1); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s >= %d AND %s <= %d", args[0], dataQuery.TimeRange.From.Unix(),
+		args[0], dataQuery.TimeRange.To.Unix()), nil
+}
+
+func unixEpochFromMacro(query *QueryModel, dataQuery *backend.DataQuery, args []string) (string, error) {
+	if err := checkMacroArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(dataQuery.TimeRange.From.Unix(), 10), nil
+}
+
+func unixEpochToMacro(query *QueryModel, dataQuery *backend.DataQuery, args []string) (string, error) {
+	if err := checkMacroArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(dataQuery.TimeRange.To.Unix(), 10), nil
+}
+
+func unixEpochNanoFromMacro(query *QueryModel, dataQuery *backend.DataQuery, args []string) (string, error) {
+	if err := checkMacroArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(dataQuery.TimeRange.From.UnixNano(), 10), nil
+}
+
+func unixEpochNanoToMacro(query *QueryModel, dataQuery *backend.DataQuery, args []string) (string, error) {
+	if err := checkMacroArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(dataQuery.TimeRange.To.UnixNano(), 10), nil
+}
+
+func timeGroupMacro(query *QueryModel, dataQuery *backend.DataQuery, args []string) (string, error) {
+	if err := checkMacroArgs(args, 2, 3); err != nil {
+		return "", err
+	}
+
+	interval, err := query.parseMacroInterval(args[1], dataQuery)
+	if err != nil {
+		return "", err
+	}
+	if len(args) == 3 {
+		fill := strings.Trim(args[2], `'"`)
+		switch strings.ToLower(fill) {
+		case "null":
+			query.Fill = "null"
+		case "previous":
+			query.Fill = "previous"
+		default:
+			if _, err := strconv.ParseFloat(fill, 64); err != nil {
+				return "", fmt.Errorf("invalid fill %q, expected NULL, previous or a number", args[2])
+			}
+			query.Fill = fill
+		}
+		// The interval is needed to resample the result.
+		query.Interval = FormatIntervalString(interval)
+	}
+
+	origin := query.Origin(dataQuery.TimeRange)
+	return fmt.Sprintf("DATE_BIN(INTERVAL '%s', %s, TIMESTAMP '%s')",
+		FormatIntervalString(interval), args[0], origin.Format(time.RFC3339)), nil
+}
+
+func timeGroupAliasMacro(query *QueryModel, dataQuery *backend.DataQuery, args []string) (string, error) {
+	res, err := timeGroupMacro(query, dataQuery, args)
+	if err != nil {
+		return "", err
+	}
+	return res + ` AS "time"`, nil
+}
+
+// parseMacroInterval parses the interval argument of a macro, which is $__interval,
+// an interval string such as '5 minutes', or a duration such as '5m'.
+func (query *QueryModel) parseMacroInterval(arg string, dataQuery *backend.DataQuery) (time.Duration, error) {
+	arg = strings.Trim(arg, `'"`)
+	if arg == "$__interval" {
+		return query.autoInterval(dataQuery), nil
+	}
+	return ParseInterval(arg)
+}
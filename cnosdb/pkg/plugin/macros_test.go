@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpolateMacros(t *testing.T) {
+	dataQuery := &backend.DataQuery{
+		Interval: 30 * time.Second,
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 10, 10, 6, 0, 0, 500, time.UTC),
+		},
+	}
+
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{
+			sql:      `SELECT * FROM t WHERE $__timeFilter(time)`,
+			expected: `SELECT * FROM t WHERE time >= TIMESTAMP '2022-10-10T00:00:00Z' AND time <= TIMESTAMP '2022-10-10T06:00:00.0000005Z'`,
+		},
+		{
+			sql:      `SELECT * FROM t WHERE time > $__timeFrom() AND time < $__timeTo()`,
+			expected: `SELECT * FROM t WHERE time > TIMESTAMP '2022-10-10T00:00:00Z' AND time < TIMESTAMP '2022-10-10T06:00:00.0000005Z'`,
+		},
+		{
+			sql:      `SELECT * FROM t WHERE $__unixEpochFilter( "ts" )`,
+			expected: `SELECT * FROM t WHERE "ts" >= 1665360000 AND "ts" <= 1665381600`,
+		},
+		{
+			sql:      `SELECT $__timeGroupAlias(time, '5m'), avg(v) FROM t GROUP BY $__timeGroup(time, 5 minutes)`,
+			expected: `SELECT DATE_BIN(INTERVAL '5 minutes', time, TIMESTAMP '1970-01-01T00:00:00Z') AS "time", avg(v) FROM t GROUP BY DATE_BIN(INTERVAL '5 minutes', time, TIMESTAMP '1970-01-01T00:00:00Z')`,
+		},
+		{
+			sql:      `SELECT $__timeGroup(date_trunc('hour', time), $__interval), $__interval_ms FROM t`,
+			expected: `SELECT DATE_BIN(INTERVAL '30 seconds', date_trunc('hour', time), TIMESTAMP '1970-01-01T00:00:00Z'), 30000 FROM t`,
+		},
+		{
+			sql:      `SELECT * FROM t WHERE $timeFilter AND "v" > $__unixEpochFrom()`,
+			expected: `SELECT * FROM t WHERE time >= 1665360000000000000 AND time <= 1665381600000000500 AND "v" > 1665360000`,
+		},
+		// Macros in string literals, quoted identifiers and comments are left as they are.
+		{
+			sql:      `SELECT '$__interval', 'it''s $timeFilter', "$__timeFrom()" FROM t -- $__timeTo(` + "\n" + `WHERE $__timeFilter(time) /* $__interval */`,
+			expected: `SELECT '$__interval', 'it''s $timeFilter', "$__timeFrom()" FROM t -- $__timeTo(` + "\n" + `WHERE time >= TIMESTAMP '2022-10-10T00:00:00Z' AND time <= TIMESTAMP '2022-10-10T06:00:00.0000005Z' /* $__interval */`,
+		},
+		{
+			sql:      `SELECT $__intervals, $unknown FROM t WHERE note = 'unterminated $__timeFrom()`,
+			expected: `SELECT $__intervals, $unknown FROM t WHERE note = 'unterminated $__timeFrom()`,
+		},
+	}
+	for _, test := range tests {
+		query := &QueryModel{RawQuery: true}
+		sql, err := query.interpolateMacros(test.sql, dataQuery)
+		require.NoError(t, err, test.sql)
+		assert.Equal(t, test.expected, sql)
+	}
+}
+
+func TestInterpolateTimeGroupFill(t *testing.T) {
+	query := &QueryModel{RawQuery: true}
+	_, err := query.interpolateMacros(`SELECT $__timeGroupAlias(time, '1h', previous) FROM t`, &backend.DataQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, "previous", query.Fill)
+	assert.Equal(t, time.Hour, ParseIntervalString(query.Interval))
+
+	query = &QueryModel{RawQuery: true}
+	_, err = query.interpolateMacros(`SELECT $__timeGroup(time, '1h', 0) FROM t`, &backend.DataQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, "0", query.Fill)
+}
+
+func TestInterpolateMalformedMacros(t *testing.T) {
+	for _, sql := range []string{
+		`SELECT * FROM t WHERE $__timeFilter()`,
+		`SELECT * FROM t WHERE $__timeFilter(time`,
+		`SELECT * FROM t WHERE $__timeFilter(time, time)`,
+		`SELECT * FROM t WHERE $__timeFilter`,
+		`SELECT * FROM t WHERE time > $__timeFrom(time)`,
+		`SELECT $__timeGroup(time) FROM t`,
+		`SELECT $__timeGroup(time, '1h', sometimes) FROM t`,
+		`SELECT $__timeGroup(time, 'often') FROM t`,
+		`SELECT $__unknown(time) FROM t`,
+	} {
+		query := &QueryModel{RawQuery: true}
+		_, err := query.interpolateMacros(sql, &backend.DataQuery{})
+		assert.Error(t, err, sql)
+	}
+}
+
+func TestInterpolateAutoInterval(t *testing.T) {
+	dataQuery := &backend.DataQuery{
+		Interval:      10 * time.Second,
+		MaxDataPoints: 100,
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 10, 10, 6, 0, 0, 0, time.UTC),
+		},
+	}
+	query := &QueryModel{RawQuery: true, MinInterval: time.Minute}
+	sql, err := query.interpolateMacros(`SELECT $__timeGroup(time, $__interval), $__interval_ms FROM t`, dataQuery)
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT DATE_BIN(INTERVAL '5 minutes', time, TIMESTAMP '1970-01-01T00:00:00Z'), 300000 FROM t`, sql)
+
+	query = &QueryModel{RawQuery: true, MinInterval: time.Hour}
+	sql, err = query.interpolateMacros(`SELECT $__interval`, dataQuery)
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT 1 hour`, sql)
+}
@@ -0,0 +1,206 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+type mathTokenKind int
+
+const (
+	mathNumber mathTokenKind = iota
+	mathIdentifier
+	mathOperator
+	mathOpen
+	mathClose
+	// mathInner stands for the inner expression the math expression is applied to.
+	mathInner
+)
+
+type mathToken struct {
+	kind mathTokenKind
+	text string
+}
+
+// tokenizeMath splits a math expression into numbers, identifiers, operators and parentheses.
+// Identifiers are field names, either double quoted or made of letters, digits and underscores.
+func tokenizeMath(expr string) ([]mathToken, error) {
+	var tokens []mathToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.IndexByte("+-*/%", c) >= 0:
+			tokens = append(tokens, mathToken{mathOperator, string(c)})
+			i++
+		case c == '(':
+			tokens = append(tokens, mathToken{mathOpen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, mathToken{mathClose, ")"})
+			i++
+		case c >= '0' && c <= '9' || c == '.':
+			j := i
+			for j < len(expr) && (isDigit(expr[j]) || expr[j] == '.' ||
+				(expr[j] == 'e' || expr[j] == 'E') ||
+				(expr[j] == '+' || expr[j] == '-') && (expr[j-1] == 'e' || expr[j-1] == 'E')) {
+				j++
+			}
+			num, err := NumberLiteral(expr[i:j])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, mathToken{mathNumber, num})
+			i = j
+		case c == '"':
+			var name strings.Builder
+			j := i + 1
+			closed := false
+			for ; j < len(expr); j++ {
+				if expr[j] != '"' {
+					name.WriteByte(expr[j])
+					continue
+				}
+				if j+1 < len(expr) && expr[j+1] == '"' {
+					name.WriteByte('"')
+					j++
+					continue
+				}
+				closed = true
+				break
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated field name in math expression %q", expr)
+			}
+			tokens = append(tokens, mathToken{mathIdentifier, QuoteIdentifier(name.String())})
+			i = j + 1
+		case isWordChar(c):
+			j := i
+			for j < len(expr) && (isWordChar(expr[j]) || isDigit(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, mathToken{mathIdentifier, QuoteIdentifier(expr[i:j])})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q in math expression %q", c, expr)
+		}
+	}
+	return tokens, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// mathParser checks that tokens form an arithmetic expression:
+//
+//	expr   := term (("+" | "-") term)*
+//	term   := factor (("*" | "/" | "%") factor)*
+//	factor := ("+" | "-") factor | number | identifier | inner | "(" expr ")"
+type mathParser struct {
+	tokens []mathToken
+	pos    int
+}
+
+func (p *mathParser) peek() *mathToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *mathParser) expr() error {
+	return p.binary("+-", p.term)
+}
+
+func (p *mathParser) term() error {
+	return p.binary("*/%", p.factor)
+}
+
+func (p *mathParser) binary(operators string, operand func() error) error {
+	if err := operand(); err != nil {
+		return err
+	}
+	for {
+		token := p.peek()
+		if token == nil || token.kind != mathOperator || !strings.Contains(operators, token.text) {
+			return nil
+		}
+		p.pos++
+		if err := operand(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *mathParser) factor() error {
+	token := p.peek()
+	if token == nil {
+		return fmt.Errorf("unexpected end of math expression")
+	}
+	p.pos++
+	switch token.kind {
+	case mathNumber, mathIdentifier, mathInner:
+		return nil
+	case mathOperator:
+		if token.text == "+" || token.text == "-" {
+			return p.factor()
+		}
+	case mathOpen:
+		if err := p.expr(); err != nil {
+			return err
+		}
+		if next := p.peek(); next == nil || next.kind != mathClose {
+			return fmt.Errorf("missing closing parenthesis in math expression")
+		}
+		p.pos++
+		return nil
+	}
+	return fmt.Errorf("unexpected %q in math expression", token.text)
+}
+
+// RenderMath validates a math expression applied to an inner expression, such as "* 8"
+// or "+ \"tx\"", and renders it from its tokens, so that it never contains anything
+// but arithmetic on numbers, fields and the inner expression.
+func RenderMath(expr string) (string, error) {
+	tokens, err := tokenizeMath(expr)
+	if err != nil {
+		return "", err
+	}
+	if len(tokens) == 0 || tokens[0].kind != mathOperator {
+		return "", fmt.Errorf("math expression %q must start with an operator", expr)
+	}
+
+	p := &mathParser{tokens: append([]mathToken{{kind: mathInner}}, tokens...)}
+	if err := p.expr(); err != nil {
+		return "", err
+	}
+	if p.pos < len(p.tokens) {
+		return "", fmt.Errorf("unexpected %q in math expression %q", p.tokens[p.pos].text, expr)
+	}
+
+	// Operators are separated by spaces, except for unary operators which are attached to their operand
+	// unless it is another unary operator, "--" would start a comment.
+	var sb strings.Builder
+	prev := mathToken{kind: mathInner}
+	prevUnary := false
+	for _, token := range tokens {
+		unary := token.kind == mathOperator && (prev.kind == mathOperator || prev.kind == mathOpen)
+		if sb.Len() > 0 && prev.kind != mathOpen && token.kind != mathClose && !(prevUnary && token.kind != mathOperator) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(token.text)
+		prev, prevUnary = token, unary
+	}
+	return sb.String(), nil
+}
+
+func validateMath(value string) error {
+	_, err := RenderMath(value)
+	return err
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderMath(t *testing.T) {
+	for expr, expected := range map[string]string{
+		"* 8":                 "* 8",
+		"/1024":               "/ 1024",
+		"*8/1024":             "* 8 / 1024",
+		`+ "tx"`:              `+ "tx"`,
+		"+ tx":                `+ "tx"`,
+		`- "a""b"`:            `- "a""b"`,
+		"* -1":                "* -1",
+		"* (1 + 2.5e-1)":      "* (1 + 0.25)",
+		"% 60 * (-rx + -(2))": `% 60 * (-"rx" + -(2))`,
+		// Never a comment.
+		"* 8 -- comment": `* 8 - -"comment"`,
+		"* --1":          "* - -1",
+	} {
+		rendered, err := RenderMath(expr)
+		require.NoError(t, err, expr)
+		assert.Equal(t, expected, rendered, expr)
+	}
+}
+
+func TestRenderMathInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"8",
+		"* ",
+		"* 8 8",
+		"* (8",
+		"* 8)",
+		"* 'a'",
+		`+ "tx`,
+		"* 8; DROP TABLE t",
+		"* now()",
+		"* 1 OR 1=1",
+		"* 1e",
+		"*/ 2",
+	} {
+		_, err := RenderMath(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestMathPart(t *testing.T) {
+	tests := []struct {
+		chain    []*SelectItem
+		expected string
+	}{
+		{
+			[]*SelectItem{{Type: "field", Params: []string{"bytes"}}, {Type: "avg"}, {Type: "math", Params: []string{"* 8"}}},
+			`(avg("bytes") * 8)`,
+		},
+		{
+			[]*SelectItem{{Type: "field", Params: []string{"rx"}}, {Type: "math", Params: []string{`+ "tx"`}}, {Type: "sum"}},
+			`sum(("rx" + "tx"))`,
+		},
+		{
+			[]*SelectItem{
+				{Type: "field", Params: []string{"bytes"}},
+				{Type: "max"},
+				{Type: "math", Params: []string{"+ 1"}},
+				{Type: "math", Params: []string{"* 2"}},
+				{Type: "alias", Params: []string{"x"}},
+			},
+			`((max("bytes") + 1) * 2) AS "x"`,
+		},
+	}
+	for _, test := range tests {
+		sql, err := renderSelect(t, test.chain...)
+		require.NoError(t, err, test.expected)
+		assert.Contains(t, sql, "SELECT time, "+test.expected+" FROM")
+	}
+
+	_, err := renderSelect(t, &SelectItem{Type: "field", Params: []string{"bytes"}}, &SelectItem{Type: "math", Params: []string{"* 8; DROP TABLE t"}})
+	assert.Error(t, err)
+}
@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -29,26 +31,43 @@ import (
 var (
 	_ backend.QueryDataHandler      = (*CnosDatasource)(nil)
 	_ backend.CheckHealthHandler    = (*CnosDatasource)(nil)
+	_ backend.CallResourceHandler   = (*CnosDatasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*CnosDatasource)(nil)
 )
 
 // NewCnosDatasource creates a new datasource instance.
 func NewCnosDatasource(instanceSettings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	var jsonData map[string]string
-	if err := json.Unmarshal(instanceSettings.JSONData, &jsonData); err != nil {
+	settings, err := LoadSettings(instanceSettings)
+	if err != nil {
 		return nil, err
 	}
 
 	log.DefaultLogger.Info(fmt.Sprintf("Building datasource: URL: '%s', db: '%s'",
 		instanceSettings.URL, instanceSettings.Database))
 
-	return &CnosDatasource{
+	ds := &CnosDatasource{
 		url:      instanceSettings.URL,
 		database: instanceSettings.Database,
-		client: http.Client{
-			Timeout: 10 * time.Second,
-		},
-	}, nil
+		settings: *settings,
+		// Requests are bounded by the context deadline of each query instead of a client timeout.
+		client:      http.Client{},
+		schemaCache: newResultCache(SCHEMA_CACHE_TTL, SCHEMA_CACHE_MAX_SIZE),
+	}
+	ds.resources = ds.newResourceHandler()
+	if settings.CacheTTL > 0 {
+		ds.cache = newResultCache(settings.CacheTTL, settings.CacheMaxSize)
+	}
+	if settings.IncrementalQueries {
+		ds.windows = newWindowStore(DEFAULT_MAX_INCREMENTAL_QUERIES, settings.IncrementalMaxSize, settings.IncrementalTTL,
+			settings.IncrementalGracePeriod)
+	}
+	if settings.DiskCacheDir != "" {
+		dir := diskCacheDir(settings.DiskCacheDir, instanceSettings.UID, instanceSettings.URL, instanceSettings.Database)
+		if ds.disk, err = newDiskCache(dir, settings.DiskCacheMaxSize); err != nil {
+			return nil, fmt.Errorf("invalid setting 'diskCacheDir': %s", err)
+		}
+	}
+	return ds, nil
 }
 
 // CnosDatasource is an example datasource which can respond to data queries, reports
@@ -56,8 +75,18 @@ func NewCnosDatasource(instanceSettings backend.DataSourceInstanceSettings) (ins
 type CnosDatasource struct {
 	url      string
 	database string
+	settings DatasourceSettings
 
 	client http.Client
+	// cache keeps query results, it is nil if caching is disabled.
+	cache *resultCache
+	// windows keeps the results of queries fetched incrementally, it is nil if they are disabled.
+	windows *windowStore
+	// disk keeps chunks of results older than the immutable age, it is nil if it is disabled.
+	disk *diskCache
+	// schemaCache keeps the responses of the statements of the resources.
+	schemaCache *resultCache
+	resources   backend.CallResourceHandler
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
@@ -78,18 +107,90 @@ func (d *CnosDatasource) QueryData(ctx context.Context, req *backend.QueryDataRe
 	// Create response struct
 	response := backend.NewQueryDataResponse()
 
-	// Loop over queries and execute them individually.
-	// TODO: Use goroutine instead of serial execution.
+	// Execute queries concurrently, at most maxConcurrentQueries at the same time.
+	// Queries still waiting for a slot are not started once ctx is cancelled.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, d.maxConcurrentQueries())
 	for _, q := range req.Queries {
-		res := d.query(ctx, req, q)
+		wg.Add(1)
+		go func(q backend.DataQuery) {
+			defer wg.Done()
+
+			var res backend.DataResponse
+			select {
+			case sem <- struct{}{}:
+				res = d.query(ctx, req, q)
+				<-sem
+			case <-ctx.Done():
+				res = backend.DataResponse{Error: ctx.Err()}
+			}
 
-		// Save the response in a hashmap based on with RefID as identifier
-		response.Responses[q.RefID] = res
+			// Save the response in a hashmap based on with RefID as identifier
+			mu.Lock()
+			response.Responses[q.RefID] = res
+			mu.Unlock()
+		}(q)
 	}
+	wg.Wait()
 
 	return response, nil
 }
 
+func (d *CnosDatasource) maxConcurrentQueries() int {
+	if d.settings.MaxConcurrentQueries > 0 {
+		return d.settings.MaxConcurrentQueries
+	}
+	return DEFAULT_MAX_CONCURRENT_QUERIES
+}
+
+func (d *CnosDatasource) maxResponseSize() int64 {
+	if d.settings.MaxResponseSize > 0 {
+		return d.settings.MaxResponseSize
+	}
+	return DEFAULT_MAX_RESPONSE_SIZE
+}
+
+func (d *CnosDatasource) queryTimeout(queryModel *QueryModel) (time.Duration, error) {
+	if queryModel.Timeout != "" {
+		timeout, err := ParseDurationString(queryModel.Timeout)
+		if err != nil {
+			return 0, fmt.Errorf("invalid query timeout: %s", err)
+		}
+		return timeout, nil
+	}
+	return d.defaultQueryTimeout(), nil
+}
+
+// defaultQueryTimeout is the timeout of the datasource, queries are only bounded by the
+// deadline of the Grafana request if it is 0.
+func (d *CnosDatasource) defaultQueryTimeout() time.Duration {
+	return d.settings.QueryTimeout
+}
+
+// withQueryTimeout bounds ctx by timeout, ctx is left to its own deadline if timeout is 0.
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// timeoutError tells which deadline killed a query if err was caused by one,
+// otherwise err is returned unchanged.
+func timeoutError(parentCtx context.Context, queryCtx context.Context, timeout time.Duration, err error) error {
+	if !errors.Is(queryCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if errors.Is(parentCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("query timed out: the Grafana request deadline was exceeded")
+	}
+	return fmt.Errorf("query timed out after %s, increase the query timeout if it needs more time", timeout)
+}
+
 func (d *CnosDatasource) query(ctx context.Context, queryContext *backend.QueryDataRequest, query backend.DataQuery) backend.DataResponse {
 	defer func() {
 		if err := recover(); err != nil {
@@ -113,136 +214,75 @@ func (d *CnosDatasource) query(ctx context.Context, queryContext *backend.QueryD
 		response.Error = err
 		return response
 	}
+	if queryModel.Tz == "" {
+		queryModel.Tz = d.settings.Timezone
+	}
+	queryModel.MinInterval = d.settings.MinInterval
+	if d.settings.ValidateSchema {
+		queryModel.Schema = &datasourceSchema{ctx: ctx, d: d, auth: auth}
+	}
 	if err = queryModel.Introspect(); err != nil {
 		response.Error = err
+		var schemaErr *SchemaError
+		if errors.As(err, &schemaErr) {
+			response.Frames = data.Frames{schemaErr.Frame()}
+		}
 		return response
 	}
 
 	dbgQueryModel, _ := json.Marshal(queryModel)
 	log.DefaultLogger.Debug("CnosDB query model", "model", string(dbgQueryModel))
 
-	// Build sql
-	sql, err := queryModel.Build(queryContext)
-	if err != nil {
-		response.Error = err
-		return response
+	// Cached results, incremental queries and chunks are shared by the queries whose time range is in
+	// the same time buckets. The interval is resolved first, since aligning changes the time range.
+	// Only the queries grouped by time are aligned, the rows of other queries outside of the time
+	// range would be returned.
+	incremental := d.windows != nil && queryModel.splittable()
+	chunked := d.disk != nil && queryModel.splittable()
+	if queryModel.groupedByTime() && (d.cache != nil || incremental || chunked) {
+		query.Interval, query.MaxDataPoints = queryModel.autoInterval(&query), 0
+		query.TimeRange = queryModel.alignTimeRange(&query)
 	}
-	log.DefaultLogger.Debug("CnosDB query sql", "sql", sql)
 
-	req, err := http.NewRequestWithContext(ctx, "POST", d.url+"/api/v1/sql?db="+d.database, strings.NewReader(sql))
+	timeout, err := d.queryTimeout(&queryModel)
 	if err != nil {
 		response.Error = err
 		return response
 	}
-	req.Header.Set("Authorization", "Basic "+auth)
-	req.Header.Set("Accept", "application/json")
-
-	// Handle response
-	res, err := d.client.Do(req)
-	if err != nil {
-		response.Error = err
-		return response
+	queryCtx, cancel := withQueryTimeout(ctx, timeout)
+	defer cancel()
+
+	var frame *data.Frame
+	var cached *cachedResult
+	switch {
+	case incremental:
+		frame, err = d.fetchIncremental(queryCtx, queryContext, &queryModel, query, auth)
+	case chunked:
+		frame, err = d.fetchChunks(queryCtx, queryContext, &queryModel, query, auth)
+	default:
+		frame, cached, err = d.fetch(queryCtx, queryContext, &queryModel, query, auth)
 	}
-
-	defer func() {
-		if err := res.Body.Close(); err != nil {
-			log.DefaultLogger.Warn("Failed to close response body", "err", err)
-		}
-	}()
-
-	respData, _ := io.ReadAll(res.Body)
-
-	if res.StatusCode/100 != 2 {
-		var errMsg map[string]string
-		respError := fmt.Sprintf("CnosDB returned error status: %s", res.Status)
-		if err := json.NewDecoder(bytes.NewReader(respData)).Decode(&errMsg); err != nil {
-			log.DefaultLogger.Error("Failed to decode request jsonData", "err", err)
-			response.Error = fmt.Errorf("%s. ()Faield to parse response: %s", respError, err)
-			return response
-		}
-		response.Error = fmt.Errorf("%s. (%s)%s", respError, errMsg["error_code"], errMsg["error_message"])
+	if err != nil {
+		response.Error = timeoutError(ctx, queryCtx, timeout, err)
 		return response
 	}
-
-	log.DefaultLogger.Debug("CnosDB query response", "response", string(respData))
-
-	var resRows []map[string]interface{}
-	var resultNotEmpty bool = true
-	if len(respData) > 0 {
-		if err := json.NewDecoder(bytes.NewReader(respData)).Decode(&resRows); err != nil {
-			log.DefaultLogger.Error("Failed to decode request jsonData", "err", err)
+	resultNotEmpty := frame.Rows() > 0
+
+	// Split results grouped by tags into one frame per series.
+	frames := data.Frames{frame}
+	if !queryModel.RawQuery && len(queryModel.GroupByTags) > 0 {
+		frames, err = SplitSeries(frame, queryModel.GroupByTags)
+		if err != nil {
+			log.DefaultLogger.Error("Failed to split series", "err", err)
 			response.Error = err
 			return response
 		}
-		log.DefaultLogger.Debug("CnosDB query response rows", "rows", resRows)
-	} else {
-		resultNotEmpty = false
-	}
-
-	// Create data frame response.
-	frame := data.NewFrame("response")
-	timeArray := make([]time.Time, len(resRows))
-	valueArrayMap := make(map[string]Array)
-	var columnArray []string
-	var columnTypes []string
-
-	if resultNotEmpty {
-		for i, row := range resRows {
-			for col, val := range row {
-				if col == "time" {
-					parsedTime, err := ParseTimeString(val.(string))
-					if err != nil {
-						log.DefaultLogger.Error("Failed to convert to time", "err", err)
-						response.Error = err
-						return response
-					}
-					timeArray[i] = parsedTime
-				} else {
-					valArr, ok := valueArrayMap[col]
-					valType := typeof(val)
-					if !ok {
-						valArr = Array{
-							stringArray:  make([]*string, len(resRows)),
-							float64Array: make([]*float64, len(resRows)),
-							boolArray:    make([]*bool, len(resRows)),
-						}
-						columnArray = append(columnArray, col)
-						columnTypes = append(columnTypes, valType)
-						valueArrayMap[col] = valArr
-					}
-					switch valType {
-					case "float64":
-						v := val.(float64)
-						valArr.float64Array[i] = &v
-					case "string":
-						v := val.(string)
-						valArr.stringArray[i] = &v
-					case "bool":
-						v := val.(bool)
-						valArr.boolArray[i] = &v
-					default:
-						log.DefaultLogger.Error("Unexpected value type", "value", val, "value_type", valType)
-					}
-				}
-			}
-		}
 	}
 
-	// Add fields.
-	frame.Fields = append(frame.Fields,
-		data.NewField("time", nil, timeArray),
-	)
-	for i, col := range columnArray {
-		switch columnTypes[i] {
-		case "float64":
-			frame.Fields = append(frame.Fields, data.NewField(col, nil, valueArrayMap[col].float64Array))
-		case "string":
-			frame.Fields = append(frame.Fields, data.NewField(col, nil, valueArrayMap[col].stringArray))
-		case "bool":
-			frame.Fields = append(frame.Fields, data.NewField(col, nil, valueArrayMap[col].boolArray))
-		default:
-			log.DefaultLogger.Debug("Unexpected column type", "column", col)
-		}
+	if err = queryModel.applyTransforms(frames); err != nil {
+		log.DefaultLogger.Error("Failed to transform frames", "err", err)
+		response.Error = err
+		return response
 	}
 
 	// Resample if needed
@@ -267,23 +307,245 @@ func (d *CnosDatasource) query(ctx context.Context, queryContext *backend.QueryD
 		}
 		interval := ParseIntervalString(queryModel.Interval)
 		if interval != 0 {
-			frame, err = Resample(frame, interval, query.TimeRange, &data.FillMissing{
-				Mode:  fillMode,
-				Value: fillValue,
-			})
-			if err != nil {
-				log.DefaultLogger.Error("Failed to Resample dataframe", "err", err)
-				frame.AppendNotices(data.Notice{Text: "Failed to Resample dataframe", Severity: data.NoticeSeverityWarning})
+			for i, f := range frames {
+				f, err = ResampleWithOrigin(f, interval, queryModel.Origin(query.TimeRange), query.TimeRange, &data.FillMissing{
+					Mode:  fillMode,
+					Value: fillValue,
+				})
+				if err != nil {
+					log.DefaultLogger.Error("Failed to Resample dataframe", "err", err)
+					f.AppendNotices(data.Notice{Text: "Failed to Resample dataframe", Severity: data.NoticeSeverityWarning})
+				}
+				frames[i] = f
 			}
 		}
 	}
 
+	applyAlias(frames, &queryModel)
+	if cached != nil {
+		markCached(frames, cached, d.cache.now())
+	}
+
 	// Add the frames to the response.
-	response.Frames = append(response.Frames, frame)
+	response.Frames = append(response.Frames, frames...)
 
 	return response
 }
 
+// fetch builds the SQL of the query and decodes its result, which is taken from the cache if it is enabled.
+// The cached result is returned if the result came from the cache.
+func (d *CnosDatasource) fetch(ctx context.Context, queryContext *backend.QueryDataRequest, queryModel *QueryModel,
+	query backend.DataQuery, auth string) (*data.Frame, *cachedResult, error) {
+	sql, err := buildSQL(queryContext, queryModel, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d.fetchSQL(ctx, queryModel, sql, query.TimeRange, auth)
+}
+
+func buildSQL(queryContext *backend.QueryDataRequest, queryModel *QueryModel, query backend.DataQuery) (string, error) {
+	sql, err := queryModel.Build(&backend.QueryDataRequest{
+		PluginContext: queryContext.PluginContext,
+		Headers:       queryContext.Headers,
+		Queries:       []backend.DataQuery{query},
+	})
+	if err != nil {
+		return "", err
+	}
+	log.DefaultLogger.Debug("CnosDB query sql", "sql", sql)
+	return sql, nil
+}
+
+// fetchSQL sends sql, the query over timeRange, to CnosDB and decodes its result.
+func (d *CnosDatasource) fetchSQL(ctx context.Context, queryModel *QueryModel, sql string, timeRange backend.TimeRange,
+	auth string) (*data.Frame, *cachedResult, error) {
+	if d.cache != nil {
+		value, cached, err := d.cache.fetch(ctx, cacheKey(sql, timeRange), cacheQuery{
+			run: func(ctx context.Context) (interface{}, []byte, error) {
+				res, err := d.execute(ctx, auth, sql)
+				if err != nil {
+					return nil, nil, err
+				}
+				defer closeBody(res)
+				// The response is only kept in memory while it fits in the cache.
+				buf := &cappedBuffer{limit: d.cache.maxSize}
+				frame, err := d.decode(io.TeeReader(res, buf))
+				if err != nil {
+					return nil, nil, err
+				}
+				body := buf.Bytes()
+				if int64(len(body)) >= d.maxResponseSize() {
+					// The response may be truncated.
+					body = nil
+				}
+				return frame, body, nil
+			},
+			decode: func(body []byte) (interface{}, error) {
+				return d.decode(bytes.NewReader(body))
+			},
+			share: shareFrame,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return value.(*data.Frame), cached, nil
+	}
+
+	res, err := d.execute(ctx, auth, sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeBody(res)
+	frame, err := d.decode(res)
+	return frame, nil, err
+}
+
+func (d *CnosDatasource) decode(body io.Reader) (*data.Frame, error) {
+	frame, err := DecodeResponse(body, d.maxResponseSize())
+	if err != nil {
+		log.DefaultLogger.Error("Failed to decode response", "err", err)
+		return nil, err
+	}
+	return frame, nil
+}
+
+// fetchIncremental fetches a query over a rolling time window. If the window of the previous
+// refresh of the query is kept, only the rows since its closed buckets are fetched and they
+// are merged with the rows of the window which are still in the time range.
+func (d *CnosDatasource) fetchIncremental(ctx context.Context, queryContext *backend.QueryDataRequest, queryModel *QueryModel,
+	query backend.DataQuery, auth string) (*data.Frame, error) {
+	key := queryModel.windowKey(&query)
+	if window, ok := d.windows.get(key); ok {
+		if from, ok := queryModel.resumableFrom(window, query.TimeRange); ok {
+			newRows := query
+			newRows.TimeRange.From = from
+			frame, _, err := d.fetch(ctx, queryContext, queryModel, newRows, auth)
+			if err != nil {
+				return nil, err
+			}
+			if frame.Meta == nil || len(frame.Meta.Notices) == 0 {
+				if merged, ok := window.merge(frame, query.TimeRange, queryModel.limit()); ok {
+					log.DefaultLogger.Debug("CnosDB incremental query", "from", from, "rows", frame.Rows())
+					if window := queryModel.newWindow(key, merged, &query, d.windows.closedBefore()); window != nil {
+						d.windows.put(window)
+					}
+					return copyFrame(merged), nil
+				}
+			}
+		}
+	}
+
+	fetchAll := d.fetchAll
+	if d.disk != nil {
+		fetchAll = d.fetchChunks
+	}
+	frame, err := fetchAll(ctx, queryContext, queryModel, query, auth)
+	if err != nil {
+		return nil, err
+	}
+	if window := queryModel.newWindow(key, frame, &query, d.windows.closedBefore()); window != nil {
+		d.windows.put(window)
+		return copyFrame(frame), nil
+	}
+	return frame, nil
+}
+
+// fetchAll fetches the whole time range of the query.
+func (d *CnosDatasource) fetchAll(ctx context.Context, queryContext *backend.QueryDataRequest, queryModel *QueryModel,
+	query backend.DataQuery, auth string) (*data.Frame, error) {
+	frame, _, err := d.fetch(ctx, queryContext, queryModel, query, auth)
+	return frame, err
+}
+
+// fetchChunks fetches the chunks of the time range which are older than the immutable age from
+// the disk cache, chunks missing in the cache are fetched and written to it. The rest of the
+// time range is fetched from CnosDB.
+func (d *CnosDatasource) fetchChunks(ctx context.Context, queryContext *backend.QueryDataRequest, queryModel *QueryModel,
+	query backend.DataQuery, auth string) (*data.Frame, error) {
+	chunks, rest := queryModel.chunkRanges(&query, d.disk.now().Add(-d.settings.ImmutableAfter))
+	if len(chunks) == 0 {
+		return d.fetchAll(ctx, queryContext, queryModel, query, auth)
+	}
+
+	limit := queryModel.limit()
+	frames := make([]*data.Frame, 0, len(chunks)+1)
+	for _, chunk := range chunks {
+		chunkQuery := query
+		chunkQuery.TimeRange = chunk
+		sql, err := buildSQL(queryContext, queryModel, chunkQuery)
+		if err != nil {
+			return nil, err
+		}
+		key := diskCacheKey(d.url, d.database, sql)
+		frame, ok := d.disk.get(key)
+		if !ok {
+			if frame, _, err = d.fetchSQL(ctx, queryModel, sql, chunk, auth); err != nil {
+				return nil, err
+			}
+			// A truncated chunk is not kept, nor merged with the other chunks.
+			if frame.Rows() >= limit || frame.Meta != nil && len(frame.Meta.Notices) > 0 {
+				return d.fetchAll(ctx, queryContext, queryModel, query, auth)
+			}
+			if err = d.disk.put(key, frame); err != nil {
+				log.DefaultLogger.Warn("Failed to write the disk cache", "err", err)
+			}
+		}
+		frames = append(frames, frame)
+	}
+	if !rest.After(query.TimeRange.To) {
+		restQuery := query
+		restQuery.TimeRange.From = rest
+		frame, _, err := d.fetch(ctx, queryContext, queryModel, restQuery, auth)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+
+	if frame, ok := concatFrames(frames, query.TimeRange, limit); ok {
+		return frame, nil
+	}
+	return d.fetchAll(ctx, queryContext, queryModel, query, auth)
+}
+
+// execute sends sql to CnosDB and returns the body of the response, which must be closed.
+func (d *CnosDatasource) execute(ctx context.Context, auth string, sql string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", d.url+"/api/v1/sql?db="+d.database, strings.NewReader(sql))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Basic "+auth)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 == 2 {
+		return res.Body, nil
+	}
+
+	defer closeBody(res.Body)
+	var errMsg map[string]string
+	respError := fmt.Sprintf("CnosDB returned error status: %s", res.Status)
+	if err := json.NewDecoder(io.LimitReader(res.Body, MAX_ERROR_RESPONSE_SIZE)).Decode(&errMsg); err != nil {
+		log.DefaultLogger.Error("Failed to decode request jsonData", "err", err)
+		return nil, fmt.Errorf("%s. ()Faield to parse response: %w", respError, err)
+	}
+	return nil, fmt.Errorf("%s. (%s)%s", respError, errMsg["error_code"], errMsg["error_message"])
+}
+
+func closeBody(body io.Closer) {
+	if err := body.Close(); err != nil {
+		log.DefaultLogger.Warn("Failed to close response body", "err", err)
+	}
+}
+
+// CallResource handles the resources of the datasource, see newResourceHandler.
+func (d *CnosDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	return d.resources.CallResource(ctx, req, sender)
+}
+
 // CheckHealth handles health checks sent from Grafana to the plugin.
 // The main use case for these health checks is the test button on the
 // datasource configuration page which allows users to verify that
@@ -291,10 +553,22 @@ func (d *CnosDatasource) query(ctx context.Context, queryContext *backend.QueryD
 func (d *CnosDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	log.DefaultLogger.Info("CnosDB check health", "request", req)
 
-	res, err := d.client.Get(d.url + "/api/v1/ping")
+	ctx, cancel := withQueryTimeout(ctx, d.defaultQueryTimeout())
+	defer cancel()
+
+	pingReq, err := http.NewRequestWithContext(ctx, "GET", d.url+"/api/v1/ping", nil)
 	if err != nil {
 		return nil, err
 	}
+	res, err := d.client.Do(pingReq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			log.DefaultLogger.Warn("Failed to close response body", "err", err)
+		}
+	}()
 
 	jsonDetails, err := io.ReadAll(res.Body)
 	if err != nil {
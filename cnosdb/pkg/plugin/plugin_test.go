@@ -2,6 +2,15 @@ package plugin_test
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -31,6 +40,423 @@ func TestQueryData(t *testing.T) {
 	}
 }
 
+func newTestDatasource(t *testing.T, url string, jsonData string) *plugin.CnosDatasource {
+	instance, err := plugin.NewCnosDatasource(backend.DataSourceInstanceSettings{
+		URL:      url,
+		Database: "public",
+		JSONData: []byte(jsonData),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instance.(*plugin.CnosDatasource)
+}
+
+func newTestPluginContext() backend.PluginContext {
+	return backend.PluginContext{
+		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			DecryptedSecureJSONData: map[string]string{"auth": "cm9vdDo="},
+		},
+	}
+}
+
+func TestNewCnosDatasourceSettings(t *testing.T) {
+	_, err := plugin.NewCnosDatasource(backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"maxConcurrentQueries":8,"queryTimeout":"2m","timezone":"Europe/Berlin","timeInterval":">10s","cacheTTL":"30s","cacheMaxSize":1048576,"incrementalQueries":true}`),
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, jsonData := range []string{
+		`{"maxConcurrentQueries":"many"}`,
+		`{"queryTimeout":"soon"}`,
+		`{"timezone":"Europe/Atlantis"}`,
+		`{"timeInterval":"often"}`,
+		`{"cacheTTL":"forever"}`,
+		`{"cacheMaxSize":0}`,
+		`{"incrementalQueries":"sometimes"}`,
+		`{"incrementalMaxSize":0}`,
+		`{"incrementalTTL":"0s"}`,
+		`{"incrementalGracePeriod":"-1m"}`,
+		`{"diskCacheMaxSize":-1}`,
+		`{"diskCacheDir":"../cache"}`,
+		`{"diskCacheDir":"/var/lib/grafana"}`,
+		`{"immutableAfter":"0s"}`,
+	} {
+		_, err = plugin.NewCnosDatasource(backend.DataSourceInstanceSettings{JSONData: []byte(jsonData)})
+		if err == nil {
+			t.Errorf("expected invalid settings error for %s", jsonData)
+		}
+	}
+}
+
+func TestQueryDataConcurrent(t *testing.T) {
+	var running, maxRunning int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte(`[{"time":"2022-10-10 00:00:00","value":1}]`))
+	}))
+	defer server.Close()
+
+	ds := newTestDatasource(t, server.URL, `{"maxConcurrentQueries":"2"}`)
+
+	var queries []backend.DataQuery
+	for _, refID := range []string{"A", "B", "C", "D", "E", "F"} {
+		queries = append(queries, backend.DataQuery{
+			RefID: refID,
+			JSON:  []byte(`{"rawQuery":true,"queryText":"SELECT * FROM t"}`),
+		})
+	}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: newTestPluginContext(),
+		Queries:       queries,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Responses) != len(queries) {
+		t.Fatalf("expected %d responses, got %d", len(queries), len(resp.Responses))
+	}
+	for refID, res := range resp.Responses {
+		if res.Error != nil {
+			t.Errorf("query %s failed: %s", refID, res.Error)
+		}
+	}
+	if maxRunning > 2 {
+		t.Errorf("expected at most 2 concurrent queries, got %d", maxRunning)
+	}
+}
+
+func TestQueryDataCancelled(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	ds := newTestDatasource(t, server.URL, `{"maxConcurrentQueries":1}`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+		PluginContext: newTestPluginContext(),
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{"rawQuery":true,"queryText":"SELECT * FROM t"}`)},
+			{RefID: "B", JSON: []byte(`{"rawQuery":true,"queryText":"SELECT * FROM t"}`)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for refID, res := range resp.Responses {
+		if res.Error == nil {
+			t.Errorf("expected query %s to be cancelled", refID)
+		}
+	}
+}
+
+func TestQueryDataTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	ds := newTestDatasource(t, server.URL, `{"queryTimeout":"10s"}`)
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: newTestPluginContext(),
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{"rawQuery":true,"queryText":"SELECT * FROM t","timeout":"50ms"}`)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := resp.Responses["A"]
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "timed out after 50ms") {
+		t.Errorf("expected a query timeout error, got %v", res.Error)
+	}
+}
+
+func TestQueryDataRequestDeadline(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	// Without a timeout of the datasource or the query, only the deadline of the request applies.
+	ds := newTestDatasource(t, server.URL, `{}`)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+		PluginContext: newTestPluginContext(),
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: []byte(`{"rawQuery":true,"queryText":"SELECT * FROM t"}`)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := resp.Responses["A"]
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "Grafana request deadline") {
+		t.Errorf("expected a request deadline error, got %v", res.Error)
+	}
+}
+
+func TestQueryDataTimezone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"time":"2022-10-10 08:00:00","v":1}]`))
+	}))
+	defer server.Close()
+
+	// The time zone does not apply to the time column, which CnosDB returns in UTC.
+	ds := newTestDatasource(t, server.URL, `{"timezone":"Asia/Shanghai"}`)
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: newTestPluginContext(),
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: []byte(`{"rawQuery":true,"queryText":"SELECT time, v FROM t"}`)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := resp.Responses["A"]
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	want := time.Date(2022, 10, 10, 8, 0, 0, 0, time.UTC)
+	if got := res.Frames[0].Fields[0].At(0).(time.Time); !got.Equal(want) {
+		t.Errorf("expected time %s, got %s", want, got)
+	}
+}
+
+func TestQueryDataCache(t *testing.T) {
+	var requests int32
+	var sqls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		body, _ := io.ReadAll(r.Body)
+		sqls = append(sqls, string(body))
+		_, _ = w.Write([]byte(`[{"time":"2022-10-10 00:00:00","value":1}]`))
+	}))
+	defer server.Close()
+
+	ds := newTestDatasource(t, server.URL, `{"cacheTTL":"1m"}`)
+
+	model := `{"table":"t","select":[[{"type":"field","params":["value"]},{"type":"avg"}]],"groupBy":[{"type":"time","params":["1m"]}]}`
+	query := func(from time.Time) backend.DataResponse {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: newTestPluginContext(),
+			Queries: []backend.DataQuery{{
+				RefID:     "A",
+				JSON:      []byte(model),
+				Interval:  time.Minute,
+				TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := resp.Responses["A"]
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		return res
+	}
+
+	// Both time ranges are in the same buckets of one minute.
+	from := time.Date(2022, 10, 10, 0, 0, 10, 0, time.UTC)
+	if res := query(from); res.Frames[0].Meta != nil {
+		t.Errorf("expected a result which is not cached, got %+v", res.Frames[0].Meta)
+	}
+	res := query(from.Add(20 * time.Second))
+	if requests != 1 {
+		t.Errorf("expected 1 request, got %d", requests)
+	}
+	meta := res.Frames[0].Meta
+	if meta == nil || meta.Custom.(map[string]interface{})["cached"] != true || len(meta.Notices) != 1 {
+		t.Errorf("expected a cached result, got %+v", meta)
+	}
+	if want := "time >= 1665360000000000000 AND time <= 1665363660000000000"; !strings.Contains(sqls[0], want) {
+		t.Errorf("expected the time range aligned to the interval, got %s", sqls[0])
+	}
+
+	query(from.Add(time.Minute))
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+
+	// Responses larger than the cache are decoded but not cached.
+	ds = newTestDatasource(t, server.URL, `{"cacheTTL":"1m","cacheMaxSize":16}`)
+	for i := 0; i < 2; i++ {
+		if res := query(from); res.Frames[0].Rows() != 1 || res.Frames[0].Meta != nil {
+			t.Errorf("expected a row which is not cached, got %+v", res.Frames[0])
+		}
+	}
+	if requests != 4 {
+		t.Errorf("expected 4 requests, got %d", requests)
+	}
+
+	// The time range of raw queries is not aligned, their rows are not time buckets.
+	model = `{"rawQuery":true,"queryText":"SELECT * FROM t WHERE $timeFilter"}`
+	query(from)
+	if want := "SELECT * FROM t WHERE time >= 1665360010000000000 AND time <= 1665363610000000000"; sqls[4] != want {
+		t.Errorf("expected the time range of the panel, got %s", sqls[4])
+	}
+}
+
+// newRangeServer returns a server which responds to queries with one row per step of their time range.
+func newRangeServer(step time.Duration, sqls *[]string) *httptest.Server {
+	timeFilter := regexp.MustCompile(`time >= (\d+) AND time <= (\d+)`)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*sqls = append(*sqls, string(body))
+		m := timeFilter.FindStringSubmatch(string(body))
+		from, _ := strconv.ParseInt(m[1], 10, 64)
+		to, _ := strconv.ParseInt(m[2], 10, 64)
+		var rows []string
+		for t := time.Unix(0, from).UTC(); !t.After(time.Unix(0, to)); t = t.Add(step) {
+			rows = append(rows, fmt.Sprintf(`{"time":"%s","v":%d}`, t.Format("2006-01-02 15:04:05"), t.Minute()))
+		}
+		_, _ = w.Write([]byte("[" + strings.Join(rows, ",") + "]"))
+	}))
+}
+
+func TestQueryDataIncremental(t *testing.T) {
+	var sqls []string
+	server := newRangeServer(time.Minute, &sqls)
+	defer server.Close()
+
+	ds := newTestDatasource(t, server.URL, `{"incrementalQueries":true}`)
+
+	query := func(model string, from time.Time) *data.Frame {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: newTestPluginContext(),
+			Queries: []backend.DataQuery{{
+				RefID:     "A",
+				JSON:      []byte(model),
+				TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := resp.Responses["A"]
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		return res.Frames[0]
+	}
+	timeAt := func(frame *data.Frame, i int) time.Time {
+		return frame.Fields[0].At(i).(time.Time)
+	}
+
+	model := `{"table":"t","select":[[{"type":"field","params":["v"]},{"type":"avg"}]],"groupBy":[{"type":"time","params":["1m"]}]}`
+	start := time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC)
+	frame := query(model, start)
+	if frame.Rows() != 61 {
+		t.Fatalf("expected 61 rows, got %d", frame.Rows())
+	}
+
+	// Only the rows since the end of the previous time range are fetched.
+	frame = query(model, start.Add(5*time.Minute+20*time.Second))
+	if !strings.Contains(sqls[1], "time >= 1665363600000000000 AND time <= 1665363960000000000") {
+		t.Errorf("expected the rows since 01:00 to be fetched, got %s", sqls[1])
+	}
+	if frame.Rows() != 62 {
+		t.Fatalf("expected 62 rows, got %d", frame.Rows())
+	}
+	if from, to := timeAt(frame, 0), timeAt(frame, 61); !from.Equal(start.Add(5*time.Minute)) || !to.Equal(start.Add(66*time.Minute)) {
+		t.Errorf("expected rows from 00:05 to 01:06, got %s to %s", from, to)
+	}
+
+	// A different model is fetched in full.
+	model2m := strings.Replace(model, `"1m"`, `"2m"`, 1)
+	query(model2m, start.Add(6*time.Minute))
+	if !strings.Contains(sqls[2], "time >= 1665360360000000000 AND time <= 1665363960000000000") {
+		t.Errorf("expected a full query, got %s", sqls[2])
+	}
+}
+
+func TestQueryDataDiskCache(t *testing.T) {
+	var sqls []string
+	server := newRangeServer(time.Hour, &sqls)
+	defer server.Close()
+
+	if err := os.Setenv(plugin.DISK_CACHE_ROOT_ENV, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(plugin.DISK_CACHE_ROOT_ENV)
+	query := func(from time.Time, to time.Time) *data.Frame {
+		// Each query uses a new datasource, as after a restart of the plugin.
+		ds := newTestDatasource(t, server.URL, `{"diskCacheDir":"cnosdb"}`)
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: newTestPluginContext(),
+			Queries: []backend.DataQuery{{
+				RefID:     "A",
+				JSON:      []byte(`{"table":"t","select":[[{"type":"field","params":["v"]},{"type":"avg"}]],"groupBy":[{"type":"time","params":["1h"]}]}`),
+				TimeRange: backend.TimeRange{From: from, To: to},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := resp.Responses["A"]
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		return res.Frames[0]
+	}
+
+	// The chunks of 10-10, 10-11, 10-12 and 10-13 are fetched.
+	from := time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC)
+	frame := query(from, from.Add(72*time.Hour))
+	if len(sqls) != 4 || frame.Rows() != 73 {
+		t.Fatalf("expected 4 queries and 73 rows, got %d queries and %d rows", len(sqls), frame.Rows())
+	}
+
+	// Every chunk is in the cache, rows out of the time range are dropped.
+	frame = query(from.Add(6*time.Hour), from.Add(30*time.Hour))
+	if len(sqls) != 4 || frame.Rows() != 25 {
+		t.Fatalf("expected no query and 25 rows, got %d queries and %d rows", len(sqls)-4, frame.Rows())
+	}
+	if first := frame.Fields[0].At(0).(time.Time); !first.Equal(from.Add(6 * time.Hour)) {
+		t.Errorf("expected the first row at 06:00, got %s", first)
+	}
+
+	// Time ranges which are not immutable yet are not cached.
+	now := time.Now()
+	query(now.Add(-time.Hour), now)
+	query(now.Add(-time.Hour), now)
+	if len(sqls) != 6 {
+		t.Errorf("expected 2 more queries, got %d", len(sqls)-4)
+	}
+}
+
 func TestResample(t *testing.T) {
 	fromDate := time.Date(2022, time.October, 10, 12, 30, 00, 0, time.UTC)
 	frame := data.NewFrame("response")
@@ -59,3 +485,36 @@ func TestResample(t *testing.T) {
 	}
 
 }
+
+func TestResampleWithOrigin(t *testing.T) {
+	fromDate := time.Date(2022, time.October, 10, 12, 15, 0, 0, time.UTC)
+	value := 10.0
+	frame := data.NewFrame("response",
+		data.NewField("time", nil, []time.Time{fromDate.Add(time.Hour)}),
+		data.NewField("col0", nil, []*float64{&value}),
+	)
+	timeRange := backend.TimeRange{
+		From: time.Date(2022, time.October, 10, 12, 30, 0, 0, time.UTC),
+		To:   time.Date(2022, time.October, 10, 15, 0, 0, 0, time.UTC),
+	}
+	origin := time.Date(1970, time.January, 1, 0, 15, 0, 0, time.UTC)
+
+	frame, err := plugin.ResampleWithOrigin(frame, time.Hour, origin, timeRange, &data.FillMissing{Mode: data.FillModeNull})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Buckets start at 12:15, the bucket of the time range's start.
+	expected := []time.Time{fromDate, fromDate.Add(time.Hour), fromDate.Add(2 * time.Hour)}
+	if frame.Rows() != len(expected) {
+		t.Fatalf("expected %d rows, got %d", len(expected), frame.Rows())
+	}
+	for i, ts := range expected {
+		if !frame.Fields[0].At(i).(time.Time).Equal(ts) {
+			t.Errorf("expected time %s at row %d, got %s", ts, i, frame.Fields[0].At(i))
+		}
+	}
+	if v, ok := frame.Fields[1].ConcreteAt(1); !ok || v.(float64) != value {
+		t.Errorf("expected value %v at row 1, got %v", value, frame.Fields[1].At(1))
+	}
+}
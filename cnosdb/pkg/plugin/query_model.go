@@ -2,17 +2,23 @@ package plugin
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
 
-const DEFAULT_LIMIT = 1000
+const (
+	DEFAULT_LIMIT = 1000
+	// DEFAULT_MAX_DATA_POINTS bounds the auto interval of queries that have neither an interval nor MaxDataPoints.
+	DEFAULT_MAX_DATA_POINTS = 1000
+)
 
 type SelectItem struct {
-	Def    *QueryDefinition
-	Type   string   `json:"type,omitempty"`
-	Params []string `json:"params,omitempty"`
+	Def    *QueryDefinition `json:"-"`
+	Type   string           `json:"type,omitempty"`
+	Params []string         `json:"params,omitempty"`
 }
 
 func (s *SelectItem) Render(query *QueryModel, queryContext *backend.QueryDataRequest, expr string) string {
@@ -24,6 +30,8 @@ type TagItem struct {
 	Operator  string `json:"operator,omitempty"`
 	Condition string `json:"condition,omitempty"`
 	Value     string `json:"value,omitempty"`
+	// Values are the values of operators taking a list, such as IN.
+	Values []string `json:"values,omitempty"`
 }
 
 type QueryModel struct {
@@ -31,85 +39,414 @@ type QueryModel struct {
 	Select      [][]*SelectItem `json:"select,omitempty"`
 	Tags        []*TagItem      `json:"tags,omitempty"`
 	RawTagsExpr string          `json:"rawTagsExpr,omitempty"`
-	GroupBy     []*SelectItem   `json:"groupBy,omitempty"`
-	Interval    string          `json:"interval,omitempty"`
-	Fill        string          `json:"fill,omitempty"`
-	OrderByTime string          `json:"orderByTime,omitempty"`
-	Limit       string          `json:"limit,omitempty"`
-	Tz          string          `json:"tz,omitempty"`
+	// AdhocFilters are the ad-hoc filters of the dashboard, they are combined with AND.
+	AdhocFilters []*TagItem `json:"adhocFilters,omitempty"`
+	// ScopedVars are the values of the template variables, they are expanded by Introspect.
+	ScopedVars  map[string]ScopedVar `json:"scopedVars,omitempty"`
+	GroupBy     []*SelectItem        `json:"groupBy,omitempty"`
+	Interval    string               `json:"interval,omitempty"`
+	Fill        string               `json:"fill,omitempty"`
+	OrderByTime string               `json:"orderByTime,omitempty"`
+	Limit       string               `json:"limit,omitempty"`
+	Tz          string               `json:"tz,omitempty"`
+	Timeout     string               `json:"timeout,omitempty"`
 
 	RawQuery  bool   `json:"rawQuery,omitempty"`
 	QueryText string `json:"queryText,omitempty"`
 	Alias     string `json:"alias,omitempty"`
+
+	// Offset shifts the origin of time buckets, it is the second parameter of time() in GroupBy.
+	Offset time.Duration `json:"-"`
+	// Location is the time zone of Tz, UTC if Tz is empty.
+	Location *time.Location `json:"-"`
+	// GroupByTags are the tag keys in GroupBy, results are split into one series per combination of their values.
+	GroupByTags []string `json:"-"`
+	// AutoInterval is set if the interval of time() is $__interval or auto, Build replaces it with the interval
+	// computed from the interval and MaxDataPoints of the query.
+	AutoInterval bool `json:"-"`
+	// MinInterval is the lower bound of the computed interval, it is the minimum interval of the datasource.
+	MinInterval time.Duration `json:"-"`
+	// Transforms are the parts of select chains computed on the result frames.
+	Transforms []*Transform `json:"-"`
+	// Schema is optional, if it is set Introspect checks the table and the columns of the query
+	// against it and returns a *SchemaError listing the problems found.
+	Schema SchemaSource `json:"-"`
+}
+
+// isAutoInterval tells whether the interval of time() is chosen by the backend.
+func isAutoInterval(interval string) bool {
+	switch interval {
+	case "$__interval", "$interval", "auto":
+		return true
+	}
+	return false
 }
 
 func (query *QueryModel) Introspect() error {
-	for _, sel := range query.Select {
-		for _, s := range sel {
-			def, exists := renders[s.Type]
-			if !exists {
-				return fmt.Errorf("missing query definition for %q", s.Type)
-			}
-			s.Def = &def
+	query.Location = time.UTC
+	if query.Tz != "" {
+		loc, err := time.LoadLocation(query.Tz)
+		if err != nil {
+			return fmt.Errorf("invalid time zone %q: %s", query.Tz, err)
 		}
+		query.Location = loc
+	}
+	if err := query.applyVariables(); err != nil {
+		return err
 	}
+	query.Transforms = nil
+	for i, sel := range query.Select {
+		sel, err := query.introspectSelect(sel)
+		if err != nil {
+			return err
+		}
+		query.Select[i] = sel
+	}
+	// The interval, offset and tags only come from GroupBy.
+	query.Interval = ""
+	query.AutoInterval = false
+	query.Offset = 0
+	query.GroupByTags = nil
 	for _, s := range query.GroupBy {
+		if err := s.introspect(); err != nil {
+			return err
+		}
 		if s.Type == "time" {
 			// from: GROUP BY time($interval)
 			// to: "GROUP BY time", "DATE_BIN(... $interval ...) AS time"
-			query.Interval = s.Params[0]
+			if len(s.Params) == 0 || isAutoInterval(s.Params[0]) {
+				query.AutoInterval = true
+				query.Interval = "$__interval"
+			} else {
+				interval, err := ParseInterval(s.Params[0])
+				if err != nil {
+					return fmt.Errorf("invalid interval %q of time()", s.Params[0])
+				}
+				query.Interval = FormatIntervalString(interval)
+			}
+			if len(s.Params) > 1 && s.Params[1] != "" {
+				offset, err := parseOffset(s.Params[1])
+				if err != nil {
+					return fmt.Errorf("invalid offset %q of time()", s.Params[1])
+				}
+				query.Offset = offset
+			}
 		} else if s.Type == "fill" {
 			query.Fill = s.Params[0]
+		} else if s.Type == "tag" {
+			query.GroupByTags = append(query.GroupByTags, s.Params[0])
 		}
-		def, exists := renders[s.Type]
-		if !exists {
-			return fmt.Errorf("missing query definition for %q", s.Type)
-		}
-		s.Def = &def
+	}
+	if err := query.introspectTags(); err != nil {
+		return err
+	}
+	if err := query.introspectOrderAndLimit(); err != nil {
+		return err
 	}
 	if query.RawQuery {
 		query.Fill = ""
+		query.Transforms = nil
+	} else if query.Schema != nil {
+		return query.checkSchema()
 	}
 
 	return nil
 }
 
+// introspectSelect resolves the definitions of a select chain, inserting the parts
+// required as the input of other parts, and collects its transforms.
+func (query *QueryModel) introspectSelect(sel []*SelectItem) ([]*SelectItem, error) {
+	var res []*SelectItem
+	for i, s := range sel {
+		if err := s.introspect(); err != nil {
+			return nil, err
+		}
+		if input := s.Def.Input; input != "" && (len(res) == 0 || res[len(res)-1].Type != input) {
+			part := &SelectItem{Type: input}
+			if err := part.introspect(); err != nil {
+				return nil, err
+			}
+			res = append(res, part)
+		}
+		if s.Type == "distinct" && (i+1 == len(sel) || sel[i+1].Type != "count") {
+			return nil, fmt.Errorf("distinct must be followed by count")
+		}
+		res = append(res, s)
+	}
+	return query.introspectTransform(res)
+}
+
+// introspectTransform adds the transform of a select chain, which must be its last part
+// before the alias. The chain is aliased by the type of the transform and its field if it
+// has no alias, so that its result column can be found, see transformAlias.
+func (query *QueryModel) introspectTransform(sel []*SelectItem) ([]*SelectItem, error) {
+	for i, s := range sel {
+		if !s.Def.Transform {
+			continue
+		}
+		rest := sel[i+1:]
+		if len(rest) > 1 || len(rest) == 1 && rest[0].Type != "alias" {
+			return nil, fmt.Errorf("%s must be the last part of the select before the alias", s.Type)
+		}
+		if len(rest) == 0 {
+			alias := &SelectItem{Type: "alias", Params: []string{query.transformAlias(s.Type, sel)}}
+			if err := alias.introspect(); err != nil {
+				return nil, err
+			}
+			sel = append(sel, alias)
+		}
+		query.Transforms = append(query.Transforms, &Transform{
+			Column: sel[len(sel)-1].Params[0],
+			Type:   s.Type,
+			Params: s.Params,
+		})
+		break
+	}
+	return sel, nil
+}
+
+// transformAlias returns the default alias of a select chain with a transform, such as
+// derivative_usage for the derivative of the field usage. A number is appended if another
+// select chain already has the alias.
+func (query *QueryModel) transformAlias(transform string, sel []*SelectItem) string {
+	alias := transform
+	for _, s := range sel {
+		if s.Type == "field" && s.Params[0] != "*" {
+			alias = transform + "_" + s.Params[0]
+			break
+		}
+	}
+	used := func(name string) bool {
+		for _, t := range query.Transforms {
+			if t.Column == name {
+				return true
+			}
+		}
+		for _, other := range query.Select {
+			for _, s := range other {
+				if s.Type == "alias" && len(s.Params) > 0 && s.Params[0] == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	res := alias
+	for n := 2; used(res); n++ {
+		res = fmt.Sprintf("%s_%d", alias, n)
+	}
+	return res
+}
+
+func (s *SelectItem) introspect() error {
+	def, exists := renders[s.Type]
+	if !exists {
+		return fmt.Errorf("missing query definition for %q", s.Type)
+	}
+	if err := def.checkParams(s); err != nil {
+		return err
+	}
+	s.Def = &def
+	return nil
+}
+
+func (query *QueryModel) introspectTags() error {
+	if err := introspectTags(query.Tags); err != nil {
+		return err
+	}
+	return introspectTags(query.AdhocFilters)
+}
+
+func introspectTags(tags []*TagItem) error {
+	for i, tag := range tags {
+		if err := tag.introspect(); err != nil {
+			return err
+		}
+		tag.Condition = strings.ToUpper(strings.TrimSpace(tag.Condition))
+		if i == 0 {
+			continue
+		}
+		switch tag.Condition {
+		case "":
+			tag.Condition = "AND"
+		case "AND", "OR":
+		default:
+			return fmt.Errorf("unsupported condition %q in the filter of tag %q", tag.Condition, tag.Key)
+		}
+	}
+	return nil
+}
+
+func (query *QueryModel) introspectOrderAndLimit() error {
+	query.OrderByTime = strings.ToUpper(strings.TrimSpace(query.OrderByTime))
+	switch query.OrderByTime {
+	case "", "ASC", "DESC":
+	default:
+		return fmt.Errorf("invalid order %q, expected ASC or DESC", query.OrderByTime)
+	}
+	if query.Limit != "" {
+		limit, err := strconv.Atoi(strings.TrimSpace(query.Limit))
+		if err != nil || limit < 0 {
+			return fmt.Errorf("invalid limit %q", query.Limit)
+		}
+		query.Limit = strconv.Itoa(limit)
+	}
+	return nil
+}
+
 func (query *QueryModel) Build(queryContext *backend.QueryDataRequest) (string, error) {
-	var res string
+	if query.AutoInterval {
+		query.Interval = FormatIntervalString(query.autoInterval(&queryContext.Queries[0]))
+	}
+
 	if query.RawQuery && query.QueryText != "" {
-		res = query.QueryText
-	} else {
-		res = query.renderSelectors(queryContext)
-		res += query.renderMeasurement()
-		res += query.renderWhereClause()
-		res += query.renderTimeFilter(queryContext)
-		res += query.renderGroupBy(queryContext)
-		res += query.renderOrderByTime()
-		res += query.renderLimit()
+		return query.interpolate(query.QueryText, queryContext)
 	}
 
-	res = strings.ReplaceAll(res, "$timeFilter", query.renderTimeFilter(queryContext))
-	res = strings.ReplaceAll(res, "$__interval", query.Interval)
+	// Only the raw tags expression may contain macros, the other parts of a visual
+	// query are quoted identifiers and literals that must be sent as they are.
+	rawTagsExpr, err := query.interpolate(query.RawTagsExpr, queryContext)
+	if err != nil {
+		return "", err
+	}
 
+	res := query.renderSelectors(queryContext)
+	res += query.renderMeasurement()
+	res += query.renderWhereClause(rawTagsExpr, queryContext)
+	res += query.renderGroupBy(queryContext)
+	res += query.renderOrderByTime()
+	res += query.renderLimit()
 	return res, nil
 }
 
-func (query *QueryModel) renderTimeFilter(queryContext *backend.QueryDataRequest) string {
-	timeRange := &queryContext.Queries[0].TimeRange
-	if timeRange == nil {
-		return ""
+// interpolate replaces $timeFilter and the macros in sql.
+func (query *QueryModel) interpolate(sql string, queryContext *backend.QueryDataRequest) (string, error) {
+	return query.interpolateMacros(sql, &queryContext.Queries[0])
+}
+
+// timeFilterExpr filters the time column by timeRange.
+func timeFilterExpr(timeRange backend.TimeRange) *whereExpr {
+	return andExpr(
+		condExpr(fmt.Sprintf("time >= %d", timeRange.From.UnixNano())),
+		condExpr(fmt.Sprintf("time <= %d", timeRange.To.UnixNano())),
+	)
+}
+
+// autoInterval returns the interval of $__interval: the interval Grafana computed for the query,
+// raised so that the time range has at most MaxDataPoints buckets and to the minimum interval.
+func (query *QueryModel) autoInterval(dataQuery *backend.DataQuery) time.Duration {
+	interval := dataQuery.Interval
+	maxDataPoints := dataQuery.MaxDataPoints
+	if maxDataPoints <= 0 && interval <= 0 {
+		maxDataPoints = DEFAULT_MAX_DATA_POINTS
+	}
+	if maxDataPoints > 0 {
+		if byPoints := dataQuery.TimeRange.Duration() / time.Duration(maxDataPoints); byPoints > interval {
+			interval = RoundInterval(byPoints)
+		}
+	}
+	if interval < query.MinInterval {
+		interval = query.MinInterval
+	}
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	return interval
+}
+
+// Origin returns the origin of time buckets, which is midnight of 1970-01-01 in the time zone
+// of the query, using the UTC offset the time zone has at the start of the time range, shifted
+// by the offset of time(). DATE_BIN buckets are a fixed interval apart, so when the time range
+// crosses a daylight saving time change, buckets of a day or more after the change start one
+// hour before or after the local midnight.
+func (query *QueryModel) Origin(timeRange backend.TimeRange) time.Time {
+	loc := query.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	name, offset := timeRange.From.In(loc).Zone()
+	return time.Date(1970, 1, 1, 0, 0, 0, 0, time.FixedZone(name, offset)).Add(query.Offset)
+}
+
+// bucketInterval returns the interval of the time buckets of the query, which is the interval
+// of the panel for raw queries and queries not grouped by time().
+func (query *QueryModel) bucketInterval(dataQuery *backend.DataQuery) time.Duration {
+	if !query.AutoInterval {
+		if interval := ParseIntervalString(query.Interval); interval > 0 {
+			return interval
+		}
+	}
+	return query.autoInterval(dataQuery)
+}
+
+// alignTimeRange extends the time range of dataQuery to the boundaries of the time buckets,
+// so that the queries made while the time range stays in the same buckets are the same.
+func (query *QueryModel) alignTimeRange(dataQuery *backend.DataQuery) backend.TimeRange {
+	interval := query.bucketInterval(dataQuery)
+	origin := query.Origin(dataQuery.TimeRange)
+	to := alignTime(dataQuery.TimeRange.To, origin, interval)
+	if to.Before(dataQuery.TimeRange.To) {
+		to = to.Add(interval)
+	}
+	return backend.TimeRange{
+		From: alignTime(dataQuery.TimeRange.From, origin, interval),
+		To:   to,
+	}
+}
+
+// groupedByTime tells if the query is built with a time() group, so that its rows are time buckets.
+func (query *QueryModel) groupedByTime() bool {
+	return !query.RawQuery && (query.Interval != "" || query.AutoInterval)
+}
+
+// splittable tells if the result of the query can be fetched in parts of its time range: it is
+// grouped by time, ordered by ascending time and the value of each time bucket only depends on its rows.
+func (query *QueryModel) splittable() bool {
+	if !query.groupedByTime() {
+		return false
+	}
+	if query.OrderByTime != "" && query.OrderByTime != "ASC" {
+		return false
+	}
+	for _, sel := range query.Select {
+		for _, part := range sel {
+			if part.Def != nil && part.Def.Window {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// limit returns the maximum rows of the result of the query.
+func (query *QueryModel) limit() int {
+	if query.Limit == "" {
+		return DEFAULT_LIMIT
 	}
-	return fmt.Sprintf("time >= %d and time <= %d", timeRange.From.UnixNano(), timeRange.To.UnixNano())
+	limit, _ := strconv.Atoi(query.Limit)
+	return limit
+}
+
+func (query *QueryModel) renderTimeBucket(queryContext *backend.QueryDataRequest) string {
+	origin := query.Origin(queryContext.Queries[0].TimeRange)
+	return fmt.Sprintf("DATE_BIN(INTERVAL '%s', time, TIMESTAMP '%s')", query.Interval, origin.Format(time.RFC3339))
 }
 
 func (query *QueryModel) renderSelectors(queryContext *backend.QueryDataRequest) string {
 	res := "SELECT "
 	if query.Interval != "" {
-		res += fmt.Sprintf("DATE_BIN(INTERVAL '%s', time, TIMESTAMP '1970-01-01T00:00:00Z') AS time, ", query.Interval)
+		res += query.renderTimeBucket(queryContext) + " AS time, "
 	} else {
 		res += "time, "
 	}
 
+	// Select the tags in GROUP BY, so that results can be split into series.
+	for _, group := range query.GroupBy {
+		if group.Type == "tag" {
+			res += group.Render(query, queryContext, "") + ", "
+		}
+	}
+
 	var selectors []string
 	for _, sel := range query.Select {
 		stk := ""
@@ -123,59 +460,22 @@ func (query *QueryModel) renderSelectors(queryContext *backend.QueryDataRequest)
 }
 
 func (query *QueryModel) renderMeasurement() string {
-	return fmt.Sprintf(` FROM %s`, query.Table)
-}
-
-func (query *QueryModel) renderTags() []string {
-	var res []string
-	for i, tag := range query.Tags {
-		str := ""
-
-		if i > 0 {
-			if tag.Condition == "" {
-				str += "AND"
-			} else {
-				str += tag.Condition
-			}
-			str += " "
-		}
-
-		// If the operator is missing we fall back to sensible defaults
-		if tag.Operator == "" {
-			tag.Operator = "="
-		}
-
-		var textValue string
-		switch tag.Operator {
-		case "<", ">":
-			textValue = tag.Value
-		default:
-			textValue = fmt.Sprintf("'%s'", strings.ReplaceAll(tag.Value, `\`, `\\`))
-		}
-
-		res = append(res, fmt.Sprintf(`%s"%s" %s %s`, str, tag.Key, tag.Operator, textValue))
-	}
-
-	return res
+	return fmt.Sprintf(` FROM %s`, QuoteIdentifier(query.Table))
 }
 
-func (query *QueryModel) renderWhereClause() string {
-	res := " WHERE "
-	if len(query.RawTagsExpr) > 0 {
-		res += "(" + query.RawTagsExpr + ")"
-		res += " AND "
-	}
-	tagsExpr := query.renderTags()
-	if len(tagsExpr) > 0 {
-		if len(tagsExpr) > 1 {
-			res += "(" + strings.Join(tagsExpr, " ") + ")"
-		} else {
-			res += tagsExpr[0]
-		}
-		res += " AND "
+// renderWhereClause renders the raw tags expression, the tag filters, the ad-hoc filters
+// and the time filter combined with AND.
+func (query *QueryModel) renderWhereClause(rawTagsExpr string, queryContext *backend.QueryDataRequest) string {
+	where := andExpr(
+		rawExpr(rawTagsExpr),
+		tagsExpr(query.Tags),
+		tagsExpr(query.AdhocFilters),
+		timeFilterExpr(queryContext.Queries[0].TimeRange),
+	)
+	if where == nil {
+		return ""
 	}
-
-	return res
+	return " WHERE " + where.String()
 }
 
 func (query *QueryModel) renderGroupBy(queryContext *backend.QueryDataRequest) string {
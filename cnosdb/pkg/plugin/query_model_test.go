@@ -8,6 +8,8 @@ import (
 
 	"github.com/cnosdb/cnosdb-grafana-datasource-backend/pkg/plugin"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseQuery(t *testing.T) {
@@ -152,3 +154,284 @@ func TestParseQuery3(t *testing.T) {
 
 	fmt.Println(sql)
 }
+
+func TestParseQueryGroupByTags(t *testing.T) {
+	var requestJson = `
+{
+    "table": "ma",
+    "select": [
+        [
+            { "type": "field", "params": [ "fa" ] },
+            { "type": "avg" }
+        ]
+    ],
+    "groupBy": [
+        { "type": "time", "params": [ "10 minutes" ] },
+        { "type": "tag", "params": [ "host" ] },
+        { "type": "tag", "params": [ "region" ] }
+    ]
+}`
+	queryContext := &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{
+				JSON: []byte(requestJson),
+				TimeRange: backend.TimeRange{
+					From: time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+					To:   time.Date(2022, 10, 17, 0, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+	}
+	var queryModel plugin.QueryModel
+	require.NoError(t, json.Unmarshal([]byte(requestJson), &queryModel))
+	require.NoError(t, queryModel.Introspect())
+	assert.Equal(t, []string{"host", "region"}, queryModel.GroupByTags)
+
+	sql, err := queryModel.Build(queryContext)
+	require.NoError(t, err)
+	assert.Contains(t, sql, `AS time, "host", "region", avg("fa") FROM`)
+	assert.Contains(t, sql, `GROUP BY DATE_BIN(INTERVAL '10 minutes', time, TIMESTAMP '1970-01-01T00:00:00Z'), "host", "region"`)
+}
+
+func TestParseQueryIntrospectTwice(t *testing.T) {
+	var requestJson = `
+{
+    "table": "ma",
+    "select": [
+        [
+            { "type": "field", "params": [ "fa" ] },
+            { "type": "avg" }
+        ]
+    ],
+    "groupBy": [
+        { "type": "time", "params": [ "1 hour", "15 minutes" ] },
+        { "type": "tag", "params": [ "host" ] }
+    ]
+}`
+	queryContext := &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{
+				JSON: []byte(requestJson),
+				TimeRange: backend.TimeRange{
+					From: time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+					To:   time.Date(2022, 10, 17, 0, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+	}
+	var queryModel plugin.QueryModel
+	require.NoError(t, json.Unmarshal([]byte(requestJson), &queryModel))
+	require.NoError(t, queryModel.Introspect())
+	sql, err := queryModel.Build(queryContext)
+	require.NoError(t, err)
+
+	require.NoError(t, queryModel.Introspect())
+	assert.Equal(t, []string{"host"}, queryModel.GroupByTags)
+	assert.Equal(t, 15*time.Minute, queryModel.Offset)
+	again, err := queryModel.Build(queryContext)
+	require.NoError(t, err)
+	assert.Equal(t, sql, again)
+
+	// Derived fields do not outlive the groups they come from.
+	queryModel.GroupBy = queryModel.GroupBy[:1]
+	queryModel.GroupBy[0].Params = queryModel.GroupBy[0].Params[:1]
+	require.NoError(t, queryModel.Introspect())
+	assert.Empty(t, queryModel.GroupByTags)
+	assert.Equal(t, time.Duration(0), queryModel.Offset)
+}
+
+func TestParseQueryTimezone(t *testing.T) {
+	var requestJson = `
+{
+    "table": "ma",
+    "select": [
+        [
+            { "type": "field", "params": [ "fa" ] },
+            { "type": "sum" }
+        ],
+        [
+            { "type": "field", "params": [ "state" ] },
+            { "type": "duration_in", "params": [ "up", "2022-10-10 08:00:00" ] }
+        ]
+    ],
+    "groupBy": [
+        { "type": "time", "params": [ "1 day" ] }
+    ],
+    "tz": "Asia/Shanghai"
+}`
+	queryContext := &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{
+				JSON: []byte(requestJson),
+				TimeRange: backend.TimeRange{
+					From: time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+					To:   time.Date(2022, 10, 17, 0, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+	}
+	var queryModel plugin.QueryModel
+	require.NoError(t, json.Unmarshal([]byte(requestJson), &queryModel))
+	require.NoError(t, queryModel.Introspect())
+
+	sql, err := queryModel.Build(queryContext)
+	require.NoError(t, err)
+	assert.Contains(t, sql, `SELECT DATE_BIN(INTERVAL '1 day', time, TIMESTAMP '1970-01-01T00:00:00+08:00') AS time`)
+	assert.Contains(t, sql, `GROUP BY DATE_BIN(INTERVAL '1 day', time, TIMESTAMP '1970-01-01T00:00:00+08:00')`)
+	// Timestamps without an offset in the query are in its time zone.
+	assert.Contains(t, sql, `duration_in(state_agg(time, "state"), 'up', TIMESTAMP '2022-10-10T00:00:00Z')`)
+
+	queryModel.Tz = "Mars/Olympus_Mons"
+	assert.Error(t, queryModel.Introspect())
+}
+
+func TestParseQueryTimeOffset(t *testing.T) {
+	var requestJson = `
+{
+    "table": "ma",
+    "select": [
+        [
+            { "type": "field", "params": [ "fa" ] },
+            { "type": "avg" }
+        ]
+    ],
+    "groupBy": [
+        { "type": "time", "params": [ "1 hour", "15 minutes" ] },
+        { "type": "fill", "params": [ "null" ] }
+    ]
+}`
+	timeRange := backend.TimeRange{
+		From: time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2022, 10, 17, 0, 0, 0, 0, time.UTC),
+	}
+	queryContext := &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{JSON: []byte(requestJson), TimeRange: timeRange}},
+	}
+	var queryModel plugin.QueryModel
+	require.NoError(t, json.Unmarshal([]byte(requestJson), &queryModel))
+	require.NoError(t, queryModel.Introspect())
+	assert.Equal(t, 15*time.Minute, queryModel.Offset)
+	assert.True(t, queryModel.Origin(timeRange).Equal(time.Date(1970, 1, 1, 0, 15, 0, 0, time.UTC)))
+
+	sql, err := queryModel.Build(queryContext)
+	require.NoError(t, err)
+	assert.Contains(t, sql, `SELECT DATE_BIN(INTERVAL '1 hour', time, TIMESTAMP '1970-01-01T00:15:00Z') AS time`)
+	assert.Contains(t, sql, `GROUP BY DATE_BIN(INTERVAL '1 hour', time, TIMESTAMP '1970-01-01T00:15:00Z')`)
+
+	for offset, want := range map[string]time.Duration{
+		"15m":       15 * time.Minute,
+		"-15m":      -15 * time.Minute,
+		"-1 hour":   -time.Hour,
+		"0s":        0,
+		"0 minutes": 0,
+	} {
+		queryModel.GroupBy[0].Params[1] = offset
+		require.NoError(t, queryModel.Introspect(), offset)
+		assert.Equal(t, want, queryModel.Offset, offset)
+	}
+
+	for _, offset := range []string{"soon", "0 fortnights", "-"} {
+		queryModel.GroupBy[0].Params[1] = offset
+		assert.Error(t, queryModel.Introspect(), offset)
+	}
+}
+
+func TestParseQueryAutoInterval(t *testing.T) {
+	var requestJson = `
+{
+    "table": "ma",
+    "select": [
+        [
+            { "type": "field", "params": [ "fa" ] },
+            { "type": "avg" }
+        ]
+    ],
+    "groupBy": [
+        { "type": "time", "params": [ "$__interval" ] }
+    ]
+}`
+	timeRange := backend.TimeRange{
+		From: time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2022, 10, 10, 6, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		interval      time.Duration
+		maxDataPoints int64
+		minInterval   time.Duration
+		expected      string
+	}{
+		{interval: 10 * time.Second, maxDataPoints: 500, expected: "30 seconds"},
+		{interval: time.Minute, maxDataPoints: 1000, expected: "1 minute"},
+		{interval: 10 * time.Second, maxDataPoints: 1000, minInterval: 5 * time.Minute, expected: "5 minutes"},
+		{expected: "20 seconds"},
+	}
+	for _, test := range tests {
+		queryContext := &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{
+				JSON:          []byte(requestJson),
+				TimeRange:     timeRange,
+				Interval:      test.interval,
+				MaxDataPoints: test.maxDataPoints,
+			}},
+		}
+		var queryModel plugin.QueryModel
+		require.NoError(t, json.Unmarshal([]byte(requestJson), &queryModel))
+		require.NoError(t, queryModel.Introspect())
+		assert.True(t, queryModel.AutoInterval)
+		queryModel.MinInterval = test.minInterval
+
+		sql, err := queryModel.Build(queryContext)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, queryModel.Interval)
+		assert.Contains(t, sql, "GROUP BY DATE_BIN(INTERVAL '"+test.expected+"', time, ")
+	}
+
+	var queryModel plugin.QueryModel
+	require.NoError(t, json.Unmarshal([]byte(requestJson), &queryModel))
+	queryModel.GroupBy[0].Params[0] = "5m"
+	require.NoError(t, queryModel.Introspect())
+	assert.False(t, queryModel.AutoInterval)
+	assert.Equal(t, "5 minutes", queryModel.Interval)
+
+	queryModel.GroupBy[0].Params[0] = "often"
+	assert.Error(t, queryModel.Introspect())
+}
+
+func TestParseQueryWhereClause(t *testing.T) {
+	var requestJson = `
+{
+    "table": "ma",
+    "select": [ [ { "type": "field", "params": [ "fa" ] } ] ],
+    "rawTagsExpr": "\"a\" = 'x' OR \"a\" = 'y'",
+    "tags": [
+        { "key": "b", "value": "1" },
+        { "key": "c", "value": "2", "condition": "OR" }
+    ],
+    "adhocFilters": [
+        { "key": "d", "operator": "!=", "value": "3" }
+    ]
+}`
+	queryContext := &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			JSON: []byte(requestJson),
+			TimeRange: backend.TimeRange{
+				From: time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2022, 10, 17, 0, 0, 0, 0, time.UTC),
+			},
+		}},
+	}
+	var queryModel plugin.QueryModel
+	require.NoError(t, json.Unmarshal([]byte(requestJson), &queryModel))
+	require.NoError(t, queryModel.Introspect())
+	sql, err := queryModel.Build(queryContext)
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT time, "fa" FROM "ma" WHERE ("a" = 'x' OR "a" = 'y') AND ("b" = '1' OR "c" = '2')`+
+		` AND "d" != '3' AND time >= 1665360000000000000 AND time <= 1665964800000000000 limit 1000`, sql)
+
+	queryModel.RawTagsExpr = ""
+	queryModel.Tags = nil
+	queryModel.AdhocFilters = nil
+	sql, err = queryModel.Build(queryContext)
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT time, "fa" FROM "ma" WHERE time >= 1665360000000000000 AND time <= 1665964800000000000 limit 1000`, sql)
+}
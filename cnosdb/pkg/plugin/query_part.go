@@ -4,37 +4,140 @@ import "github.com/grafana/grafana-plugin-sdk-go/backend"
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var renders map[string]QueryDefinition
 
 type DefinitionParameters struct {
-	Name string
-	Type string
+	Name     string
+	Type     string
+	Optional bool
+	// Validate checks the value of the parameter, it is optional.
+	Validate func(value string) error
 }
 
 type QueryDefinition struct {
 	Renderer func(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string
 	Params   []DefinitionParameters
+	// Input is the type of the part the input of this part must come from, such as gauge_agg for rate.
+	// Introspect inserts it before the part if the select chain does not have it.
+	Input string
+	// Transform parts are computed on the result frames, see frameTransforms.
+	Transform bool
+	// Window parts are computed over the rows of a series or of the whole time range, so their
+	// value in a time bucket depends on the rows of other buckets.
+	Window bool
+	// Numeric parts only take numbers as their input, Introspect checks it against the schema.
+	Numeric bool
+	// Aggregate parts combine the values of a time bucket or of a series into a new value.
+	Aggregate bool
 }
 
 func init() {
 	renders = make(map[string]QueryDefinition)
 
-	renders["field"] = QueryDefinition{Renderer: fieldRenderer}
+	renders["field"] = QueryDefinition{
+		Renderer: fieldRenderer,
+		Params:   []DefinitionParameters{{Name: "field", Type: "field"}},
+	}
+
+	renders["avg"] = QueryDefinition{Renderer: functionRenderer, Numeric: true, Aggregate: true}
+	renders["count"] = QueryDefinition{Renderer: functionRenderer, Aggregate: true}
+	renders["min"] = QueryDefinition{Renderer: functionRenderer, Aggregate: true}
+	renders["max"] = QueryDefinition{Renderer: functionRenderer, Aggregate: true}
+	renders["sum"] = QueryDefinition{Renderer: functionRenderer, Numeric: true, Aggregate: true}
+	renders["stddev"] = QueryDefinition{Renderer: functionRenderer, Numeric: true, Aggregate: true}
+	renders["variance"] = QueryDefinition{Renderer: functionRenderer, Numeric: true, Aggregate: true}
+	renders["median"] = QueryDefinition{Renderer: functionRenderer, Numeric: true, Aggregate: true}
+	renders["mode"] = QueryDefinition{Renderer: functionRenderer, Aggregate: true}
+	renders["spread"] = QueryDefinition{Renderer: spreadRenderer, Numeric: true, Aggregate: true}
+	// distinct is only allowed before count, as in count(DISTINCT "x").
+	renders["distinct"] = QueryDefinition{Renderer: distinctRenderer}
+
+	renders["first"] = QueryDefinition{Renderer: timeFunctionRenderer, Aggregate: true}
+	renders["last"] = QueryDefinition{Renderer: timeFunctionRenderer, Aggregate: true}
+	renders["approx_percentile_cont"] = QueryDefinition{
+		Renderer:  functionRenderer,
+		Params:    []DefinitionParameters{{Name: "percentile", Type: "number", Validate: validatePercentile}},
+		Numeric:   true,
+		Aggregate: true,
+	}
+
+	// Time series functions of CnosDB.
+	renders["increase"] = QueryDefinition{Renderer: increaseRenderer, Numeric: true, Aggregate: true}
+	renders["gauge_agg"] = QueryDefinition{Renderer: timeFunctionRenderer, Numeric: true, Aggregate: true}
+	renders["delta"] = QueryDefinition{Renderer: functionRenderer, Input: "gauge_agg"}
+	renders["time_delta"] = QueryDefinition{Renderer: functionRenderer, Input: "gauge_agg"}
+	renders["rate"] = QueryDefinition{Renderer: functionRenderer, Input: "gauge_agg"}
+	renders["state_agg"] = QueryDefinition{Renderer: timeFunctionRenderer, Aggregate: true}
+	renders["duration_in"] = QueryDefinition{
+		Renderer: functionRenderer,
+		Params: []DefinitionParameters{
+			{Name: "state", Type: "string"},
+			{Name: "start", Type: "timestamp", Optional: true, Validate: validateTimestamp},
+			{Name: "interval", Type: "time", Optional: true, Validate: validateInterval},
+		},
+		Input: "state_agg",
+	}
+	renders["sample"] = QueryDefinition{
+		Renderer:  functionRenderer,
+		Params:    []DefinitionParameters{{Name: "n", Type: "number", Validate: validatePositiveInteger}},
+		Window:    true,
+		Aggregate: true,
+	}
+	renders["asap_smooth"] = QueryDefinition{
+		Renderer:  timeFunctionRenderer,
+		Params:    []DefinitionParameters{{Name: "resolution", Type: "number", Validate: validatePositiveInteger}},
+		Window:    true,
+		Numeric:   true,
+		Aggregate: true,
+	}
+
+	// Transformations of InfluxQL. The cumulative sum is a SQL window function, the others are
+	// computed on the result frames since LAG and AVG() OVER do not skip null values.
+	renders["difference"] = QueryDefinition{
+		Renderer:  transformRenderer,
+		Params:    []DefinitionParameters{{Name: "reset", Type: "string", Optional: true, Validate: validateReset}},
+		Transform: true,
+		Numeric:   true,
+	}
+	renders["cumulative_sum"] = QueryDefinition{
+		Renderer: cumulativeSumRenderer,
+		Params:   []DefinitionParameters{{Name: "period", Type: "time", Optional: true, Validate: validateInterval}},
+		Window:   true,
+		Numeric:  true,
+	}
+	renders["moving_average"] = QueryDefinition{
+		Renderer:  transformRenderer,
+		Params:    []DefinitionParameters{{Name: "n", Type: "number", Validate: validatePositiveInteger}},
+		Transform: true,
+		Numeric:   true,
+	}
+	renders["derivative"] = QueryDefinition{
+		Renderer:  transformRenderer,
+		Params:    []DefinitionParameters{{Name: "unit", Type: "time", Optional: true, Validate: validateInterval}},
+		Transform: true,
+		Numeric:   true,
+	}
+	renders["non_negative_derivative"] = QueryDefinition{
+		Renderer:  transformRenderer,
+		Params:    []DefinitionParameters{{Name: "unit", Type: "time", Optional: true, Validate: validateInterval}},
+		Transform: true,
+		Numeric:   true,
+	}
 
-	renders["avg"] = QueryDefinition{Renderer: functionRenderer}
-	renders["count"] = QueryDefinition{Renderer: functionRenderer}
-	renders["min"] = QueryDefinition{Renderer: functionRenderer}
-	renders["max"] = QueryDefinition{Renderer: functionRenderer}
-	renders["sum"] = QueryDefinition{Renderer: functionRenderer}
-	renders["stddev"] = QueryDefinition{Renderer: functionRenderer}
-	renders["variance"] = QueryDefinition{Renderer: functionRenderer}
+	renders["math"] = QueryDefinition{
+		Renderer: suffixRenderer,
+		Params:   []DefinitionParameters{{Name: "expr", Type: "math", Validate: validateMath}},
+		Numeric:  true,
+	}
 
 	renders["time"] = QueryDefinition{
 		Renderer: timeRenderer,
-		Params:   []DefinitionParameters{{Name: "interval", Type: "time"}, {Name: "offset", Type: "time"}},
+		Params:   []DefinitionParameters{{Name: "interval", Type: "time", Optional: true}, {Name: "offset", Type: "time", Optional: true}},
 	}
 
 	renders["fill"] = QueryDefinition{
@@ -44,43 +147,211 @@ func init() {
 
 	renders["tag"] = QueryDefinition{
 		Renderer: fieldRenderer,
-		Params:   []DefinitionParameters{{Name: "tag", Type: "string"}},
+		Params:   []DefinitionParameters{{Name: "tag", Type: "field"}},
+	}
+
+	renders["alias"] = QueryDefinition{
+		Renderer: aliasRenderer,
+		Params:   []DefinitionParameters{{Name: "name", Type: "string"}},
+	}
+}
+
+// checkParams checks that part has the required parameters and at most the parameters
+// of the definition, that parameters of type "number" are numbers and that they pass
+// the validation of their definition.
+func (def *QueryDefinition) checkParams(part *SelectItem) error {
+	if len(part.Params) > len(def.Params) {
+		return fmt.Errorf("%q expects at most %d parameters, got %d", part.Type, len(def.Params), len(part.Params))
+	}
+	for i := len(part.Params); i < len(def.Params); i++ {
+		if !def.Params[i].Optional {
+			return fmt.Errorf("missing parameter %q of %q", def.Params[i].Name, part.Type)
+		}
+	}
+	for i, param := range part.Params {
+		if def.Params[i].Type == "number" {
+			if _, err := NumberLiteral(param); err != nil {
+				return fmt.Errorf("invalid parameter %q of %q: %s", def.Params[i].Name, part.Type, err)
+			}
+		}
+		if def.Params[i].Validate != nil {
+			if err := def.Params[i].Validate(param); err != nil {
+				return fmt.Errorf("invalid parameter %q of %q: %s", def.Params[i].Name, part.Type, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validatePercentile(value string) error {
+	percentile, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || percentile < 0 || percentile > 1 {
+		return fmt.Errorf("%q is not a number between 0 and 1", value)
+	}
+	return nil
+}
+
+func validatePositiveInteger(value string) error {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("%q is not a positive integer", value)
+	}
+	return nil
+}
+
+func validateInterval(value string) error {
+	_, err := ParseInterval(value)
+	return err
+}
+
+// validateReset checks the reset option of difference, see differenceTransform.
+func validateReset(value string) error {
+	switch value {
+	case "", RESET_COUNTER, RESET_NULL:
+		return nil
+	}
+	return fmt.Errorf("invalid reset %q, expected %s or %s", value, RESET_COUNTER, RESET_NULL)
+}
+
+func validateTimestamp(value string) error {
+	if _, err := ParseTimeString(value); err != nil {
+		return fmt.Errorf("invalid timestamp %q", value)
 	}
+	return nil
+}
 
-	renders["alias"] = QueryDefinition{Renderer: aliasRenderer}
+// renderParam renders the i-th parameter of part as a literal of its type, timestamps
+// without a time zone offset are in the time zone of the query.
+func renderParam(query *QueryModel, part *SelectItem, i int) string {
+	param := part.Params[i]
+	if part.Def == nil || i >= len(part.Def.Params) {
+		return QuoteString(param)
+	}
+	switch part.Def.Params[i].Type {
+	case "number":
+		if num, err := NumberLiteral(param); err == nil {
+			return num
+		}
+	case "field":
+		return QuoteIdentifier(param)
+	case "time":
+		if interval, err := ParseInterval(param); err == nil {
+			return fmt.Sprintf("INTERVAL %s", QuoteString(FormatIntervalString(interval)))
+		}
+	case "timestamp":
+		if t, err := ParseTimeStringInLocation(param, query.Location); err == nil {
+			return renderTimestamp(t)
+		}
+	}
+	return QuoteString(param)
 }
 
 func fieldRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
 	if part.Params[0] == "*" {
 		return "*"
 	}
-	return fmt.Sprintf(`"%s"`, part.Params[0])
+	return QuoteIdentifier(part.Params[0])
 }
 
 func timeRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
 	if query.Interval == "" {
 		return "time"
 	} else {
-		return fmt.Sprintf("DATE_BIN(INTERVAL '%s', time, TIMESTAMP '1970-01-01T00:00:00Z')", query.Interval)
+		return query.renderTimeBucket(queryContext)
 	}
 }
 
 func functionRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
+	var params []string
 	if innerExpr != "" {
-		part.Params = append([]string{innerExpr}, part.Params...)
+		params = append(params, innerExpr)
+	}
+	for i := range part.Params {
+		params = append(params, renderParam(query, part, i))
+	}
+
+	return fmt.Sprintf("%s(%s)", part.Type, strings.Join(params, ", "))
+}
+
+// timeFunctionRenderer renders functions taking the time and the value, such as first and gauge_agg.
+func timeFunctionRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
+	params := []string{"time", innerExpr}
+	for i := range part.Params {
+		params = append(params, renderParam(query, part, i))
 	}
+	return fmt.Sprintf("%s(%s)", part.Type, strings.Join(params, ", "))
+}
 
-	params := strings.Join(part.Params, ", ")
+// increaseRenderer renders increase, which needs the values ordered by time.
+func increaseRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
+	return fmt.Sprintf("increase(time, %s ORDER BY time)", innerExpr)
+}
+
+func spreadRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
+	return fmt.Sprintf("(max(%s) - min(%s))", innerExpr, innerExpr)
+}
+
+func distinctRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
+	return "DISTINCT " + innerExpr
+}
+
+// renderWindow renders the window of window functions: rows of the same series ordered by time.
+// The rows are also partitioned by partition if it is not empty.
+func (query *QueryModel) renderWindow(queryContext *backend.QueryDataRequest, partition string, frame string) string {
+	var clauses []string
+	var keys []string
+	for _, key := range query.GroupByTags {
+		keys = append(keys, QuoteIdentifier(key))
+	}
+	if partition != "" {
+		keys = append(keys, partition)
+	}
+	if len(keys) > 0 {
+		clauses = append(clauses, "PARTITION BY "+strings.Join(keys, ", "))
+	}
+	if query.Interval != "" {
+		clauses = append(clauses, "ORDER BY "+query.renderTimeBucket(queryContext))
+	} else {
+		clauses = append(clauses, "ORDER BY time")
+	}
+	if frame != "" {
+		clauses = append(clauses, frame)
+	}
+	return "OVER (" + strings.Join(clauses, " ") + ")"
+}
+
+// cumulativeSumRenderer renders the running sum of the values, null values are skipped. If the
+// period is set, the sum restarts at each period, whose boundaries are aligned like time buckets.
+// The periods of a query grouped by time are computed from the time buckets, since the time
+// column is not a key of the GROUP BY.
+func cumulativeSumRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
+	partition := ""
+	if len(part.Params) > 0 && part.Params[0] != "" {
+		timeExpr := "time"
+		if query.Interval != "" {
+			timeExpr = query.renderTimeBucket(queryContext)
+		}
+		origin := query.Origin(queryContext.Queries[0].TimeRange)
+		partition = fmt.Sprintf("DATE_BIN(%s, %s, TIMESTAMP '%s')", renderParam(query, part, 0), timeExpr, origin.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("SUM(%s) %s", innerExpr,
+		query.renderWindow(queryContext, partition, "ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW"))
+}
 
-	return fmt.Sprintf("%s(%s)", part.Type, params)
+// transformRenderer renders parts computed on the result frames, they select their input as it is.
+func transformRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
+	return innerExpr
 }
 
+// suffixRenderer renders the math expression after the inner expression, the math expression
+// is validated by Introspect.
 func suffixRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
-	return fmt.Sprintf("%s %s", innerExpr, part.Params[0])
+	suffix, _ := RenderMath(part.Params[0])
+	return fmt.Sprintf("(%s %s)", innerExpr, suffix)
 }
 
 func aliasRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
-	return fmt.Sprintf(`%s AS "%s"`, innerExpr, part.Params[0])
+	return fmt.Sprintf(`%s AS %s`, innerExpr, QuoteIdentifier(part.Params[0]))
 }
 
 func emptyRenderer(query *QueryModel, queryContext *backend.QueryDataRequest, part *SelectItem, innerExpr string) string {
@@ -0,0 +1,206 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// renderSelect renders the select chain of a visual query.
+func renderSelect(t *testing.T, chain ...*SelectItem) (string, error) {
+	query := &QueryModel{Table: "t", Select: [][]*SelectItem{chain}}
+	if err := query.Introspect(); err != nil {
+		return "", err
+	}
+	sql, err := query.Build(&backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			TimeRange: backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)},
+		}},
+	})
+	require.NoError(t, err)
+	return sql, nil
+}
+
+func TestSelectorsAndAggregates(t *testing.T) {
+	field := &SelectItem{Type: "field", Params: []string{"usage"}}
+	tests := []struct {
+		chain    []*SelectItem
+		expected string
+	}{
+		{[]*SelectItem{field, {Type: "first"}}, `first(time, "usage")`},
+		{[]*SelectItem{field, {Type: "last"}, {Type: "alias", Params: []string{"latest"}}}, `last(time, "usage") AS "latest"`},
+		{[]*SelectItem{field, {Type: "median"}}, `median("usage")`},
+		{[]*SelectItem{field, {Type: "mode"}}, `mode("usage")`},
+		{[]*SelectItem{field, {Type: "approx_percentile_cont", Params: []string{"0.95"}}}, `approx_percentile_cont("usage", 0.95)`},
+		{[]*SelectItem{field, {Type: "distinct"}, {Type: "count"}}, `count(DISTINCT "usage")`},
+		{[]*SelectItem{field, {Type: "spread"}}, `(max("usage") - min("usage"))`},
+	}
+	for _, test := range tests {
+		sql, err := renderSelect(t, test.chain...)
+		require.NoError(t, err, test.expected)
+		assert.Contains(t, sql, "SELECT time, "+test.expected+" FROM")
+	}
+}
+
+func TestSelectorsAndAggregatesInvalid(t *testing.T) {
+	field := &SelectItem{Type: "field", Params: []string{"usage"}}
+	for _, chain := range [][]*SelectItem{
+		{field, {Type: "approx_percentile_cont"}},
+		{field, {Type: "approx_percentile_cont", Params: []string{"95"}}},
+		{field, {Type: "approx_percentile_cont", Params: []string{"-0.1"}}},
+		{field, {Type: "approx_percentile_cont", Params: []string{"high"}}},
+		{field, {Type: "approx_percentile_cont", Params: []string{"0.5", "0.9"}}},
+		{field, {Type: "distinct"}},
+		{field, {Type: "distinct"}, {Type: "sum"}},
+		{field, {Type: "median", Params: []string{"1"}}},
+	} {
+		_, err := renderSelect(t, chain...)
+		assert.Error(t, err, chain[1].Type)
+	}
+}
+
+func TestTimeSeriesFunctions(t *testing.T) {
+	field := &SelectItem{Type: "field", Params: []string{"bytes"}}
+	tests := []struct {
+		chain    []*SelectItem
+		expected string
+	}{
+		{[]*SelectItem{field, {Type: "increase"}}, `increase(time, "bytes" ORDER BY time)`},
+		{[]*SelectItem{field, {Type: "gauge_agg"}}, `gauge_agg(time, "bytes")`},
+		{[]*SelectItem{field, {Type: "gauge_agg"}, {Type: "delta"}}, `delta(gauge_agg(time, "bytes"))`},
+		{[]*SelectItem{field, {Type: "delta"}}, `delta(gauge_agg(time, "bytes"))`},
+		{[]*SelectItem{field, {Type: "time_delta"}}, `time_delta(gauge_agg(time, "bytes"))`},
+		{[]*SelectItem{field, {Type: "rate"}, {Type: "alias", Params: []string{"rate"}}}, `rate(gauge_agg(time, "bytes")) AS "rate"`},
+		{[]*SelectItem{field, {Type: "state_agg"}}, `state_agg(time, "bytes")`},
+		{[]*SelectItem{field, {Type: "duration_in", Params: []string{"running"}}}, `duration_in(state_agg(time, "bytes"), 'running')`},
+		{
+			[]*SelectItem{field, {Type: "state_agg"}, {Type: "duration_in", Params: []string{"it's", "2022-10-10T00:00:00Z", "1h"}}},
+			`duration_in(state_agg(time, "bytes"), 'it''s', TIMESTAMP '2022-10-10T00:00:00Z', INTERVAL '1 hour')`,
+		},
+		{[]*SelectItem{field, {Type: "sample", Params: []string{"5"}}}, `sample("bytes", 5)`},
+		{[]*SelectItem{field, {Type: "asap_smooth", Params: []string{"100"}}}, `asap_smooth(time, "bytes", 100)`},
+	}
+	for _, test := range tests {
+		sql, err := renderSelect(t, test.chain...)
+		require.NoError(t, err, test.expected)
+		assert.Contains(t, sql, "SELECT time, "+test.expected+" FROM")
+	}
+
+	for _, chain := range [][]*SelectItem{
+		{field, {Type: "duration_in"}},
+		{field, {Type: "duration_in", Params: []string{"running", "yesterday"}}},
+		{field, {Type: "duration_in", Params: []string{"running", "2022-10-10T00:00:00Z", "often"}}},
+		{field, {Type: "sample", Params: []string{"0"}}},
+		{field, {Type: "sample", Params: []string{"2.5"}}},
+		{field, {Type: "asap_smooth"}},
+	} {
+		_, err := renderSelect(t, chain...)
+		assert.Error(t, err, chain[1].Type)
+	}
+}
+
+func TestTransformations(t *testing.T) {
+	field := &SelectItem{Type: "field", Params: []string{"bytes"}}
+	tests := []struct {
+		chain    []*SelectItem
+		expected string
+	}{
+		{[]*SelectItem{field, {Type: "difference"}}, `"bytes" AS "difference_bytes"`},
+		{[]*SelectItem{field, {Type: "difference", Params: []string{"counter"}}}, `"bytes" AS "difference_bytes"`},
+		{
+			[]*SelectItem{field, {Type: "cumulative_sum"}},
+			`SUM("bytes") OVER (ORDER BY time ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW)`,
+		},
+		{
+			[]*SelectItem{field, {Type: "cumulative_sum", Params: []string{"1d"}}},
+			`SUM("bytes") OVER (PARTITION BY DATE_BIN(INTERVAL '1 day', time, TIMESTAMP '1970-01-01T00:00:00Z')` +
+				` ORDER BY time ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW)`,
+		},
+		{[]*SelectItem{field, {Type: "moving_average", Params: []string{"3"}}}, `"bytes" AS "moving_average_bytes"`},
+		{[]*SelectItem{field, {Type: "derivative", Params: []string{"1s"}}}, `"bytes" AS "derivative_bytes"`},
+		{[]*SelectItem{field, {Type: "non_negative_derivative"}, {Type: "alias", Params: []string{"rx"}}}, `"bytes" AS "rx"`},
+	}
+	for _, test := range tests {
+		sql, err := renderSelect(t, test.chain...)
+		require.NoError(t, err, test.expected)
+		assert.Contains(t, sql, "SELECT time, "+test.expected+" FROM")
+	}
+
+	for _, chain := range [][]*SelectItem{
+		{field, {Type: "moving_average"}},
+		{field, {Type: "moving_average", Params: []string{"0"}}},
+		{field, {Type: "derivative", Params: []string{"often"}}},
+		{field, {Type: "derivative"}, {Type: "max"}},
+		{field, {Type: "difference", Params: []string{"wrap"}}},
+		{field, {Type: "cumulative_sum", Params: []string{"often"}}},
+	} {
+		_, err := renderSelect(t, chain...)
+		assert.Error(t, err, chain[1].Type)
+	}
+}
+
+func TestTransformationsGroupedByTime(t *testing.T) {
+	query := &QueryModel{
+		Table: "t",
+		Select: [][]*SelectItem{{
+			{Type: "field", Params: []string{"bytes"}},
+			{Type: "max"},
+			{Type: "difference"},
+		}, {
+			{Type: "field", Params: []string{"bytes"}},
+			{Type: "max"},
+			{Type: "non_negative_derivative", Params: []string{"1m"}},
+		}, {
+			{Type: "field", Params: []string{"bytes"}},
+			{Type: "sum"},
+			{Type: "cumulative_sum", Params: []string{"1d"}},
+		}},
+		GroupBy: []*SelectItem{
+			{Type: "time", Params: []string{"1m"}},
+			{Type: "tag", Params: []string{"host"}},
+		},
+	}
+	require.NoError(t, query.Introspect())
+	sql, err := query.Build(&backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			TimeRange: backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)},
+		}},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, sql, `max("bytes") AS "difference_bytes", max("bytes") AS "non_negative_derivative_bytes", `)
+	// The periods of the cumulative sum are computed from the GROUP BY key, not from the time column.
+	bucket := `DATE_BIN(INTERVAL '1 minute', time, TIMESTAMP '1970-01-01T00:00:00Z')`
+	assert.Contains(t, sql, `GROUP BY `+bucket+`, "host"`)
+	assert.Contains(t, sql, `SUM(sum("bytes")) OVER (PARTITION BY "host", DATE_BIN(INTERVAL '1 day', `+bucket+
+		`, TIMESTAMP '1970-01-01T00:00:00Z') ORDER BY `+bucket+` ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW)`)
+	assert.NotContains(t, sql, `INTERVAL '1 day', time,`)
+	assert.Equal(t, []*Transform{
+		{Column: "difference_bytes", Type: "difference"},
+		{Column: "non_negative_derivative_bytes", Type: "non_negative_derivative", Params: []string{"1m"}},
+	}, query.Transforms)
+
+	// The default aliases of transforms of the same field are numbered.
+	query = &QueryModel{
+		Table: "t",
+		Select: [][]*SelectItem{
+			{{Type: "field", Params: []string{"bytes"}}, {Type: "derivative", Params: []string{"1s"}}},
+			{{Type: "field", Params: []string{"bytes"}}, {Type: "derivative", Params: []string{"1m"}}},
+			{{Type: "field", Params: []string{"rx"}}, {Type: "alias", Params: []string{"derivative_bytes_3"}}},
+		},
+	}
+	require.NoError(t, query.Introspect())
+	assert.Equal(t, "derivative_bytes", query.Transforms[0].Column)
+	assert.Equal(t, "derivative_bytes_2", query.Transforms[1].Column)
+	query = &QueryModel{
+		Table: "t",
+		Select: [][]*SelectItem{
+			{{Type: "field", Params: []string{"bytes"}}, {Type: "derivative"}},
+			{{Type: "field", Params: []string{"rx"}}, {Type: "alias", Params: []string{"derivative_bytes"}}},
+		},
+	}
+	require.NoError(t, query.Introspect())
+	assert.Equal(t, "derivative_bytes_2", query.Transforms[0].Column)
+}
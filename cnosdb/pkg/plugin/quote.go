@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// QuoteIdentifier quotes the name of a table, field or tag, embedded double quotes are doubled.
+func QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteString quotes a string literal, embedded single quotes are doubled.
+func QuoteString(value string) string {
+	return `'` + strings.ReplaceAll(value, `'`, `''`) + `'`
+}
+
+// NumberLiteral formats value as a numeric literal, it fails if value is not a finite number.
+// The literal is formatted from the parsed number, so it never contains anything but a number.
+func NumberLiteral(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if num, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return strconv.FormatInt(num, 10), nil
+	}
+	if num, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return strconv.FormatUint(num, 10), nil
+	}
+	num, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(num, 0) || math.IsNaN(num) {
+		return "", fmt.Errorf("invalid number %q", value)
+	}
+	return strconv.FormatFloat(num, 'g', -1, 64), nil
+}
@@ -0,0 +1,201 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// adversarialInputs try to break out of identifiers and literals.
+var adversarialInputs = []string{
+	`x`,
+	`'`,
+	`"`,
+	`''`,
+	`""`,
+	`\`,
+	`\'`,
+	`\"`,
+	`x' OR '1'='1`,
+	`x" OR "1"="1`,
+	`'; DROP TABLE t; --`,
+	`"; DROP TABLE t; --`,
+	`x') OR 1=1 --`,
+	`x/* comment */`,
+	`1; DELETE FROM t`,
+	`1 OR 1=1`,
+	`$__timeFrom()`,
+	`$timeFilter`,
+	`$__timeGroup(time, '1m'`,
+	"x\n' OR 1=1 --",
+	"x\x00'",
+	`🙂'"`,
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, `"host"`, QuoteIdentifier("host"))
+	assert.Equal(t, `"a""b"`, QuoteIdentifier(`a"b`))
+	assert.Equal(t, `"a'b"`, QuoteIdentifier(`a'b`))
+	assert.Equal(t, `""""`, QuoteIdentifier(`"`))
+}
+
+func TestQuoteString(t *testing.T) {
+	assert.Equal(t, `'server'`, QuoteString("server"))
+	assert.Equal(t, `'it''s'`, QuoteString("it's"))
+	assert.Equal(t, `'a"b'`, QuoteString(`a"b`))
+	assert.Equal(t, `'\'''`, QuoteString(`\'`))
+}
+
+func TestNumberLiteral(t *testing.T) {
+	for input, expected := range map[string]string{
+		"10":                   "10",
+		" -3 ":                 "-3",
+		"0.5":                  "0.5",
+		"1e3":                  "1000",
+		"18446744073709551615": "18446744073709551615",
+	} {
+		literal, err := NumberLiteral(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, literal, input)
+	}
+
+	for _, input := range []string{"", "ten", "1 OR 1=1", "1;", "NaN", "Inf", "-infinity", "1e400"} {
+		_, err := NumberLiteral(input)
+		assert.Error(t, err, input)
+	}
+}
+
+// tokenizeSQL replaces each quoted identifier by "?" and each string literal by '?',
+// returning the remaining SQL and the unquoted contents of identifiers and literals.
+func tokenizeSQL(sql string) (string, []string, error) {
+	var skeleton strings.Builder
+	var tokens []string
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		if c != '\'' && c != '"' {
+			skeleton.WriteByte(c)
+			continue
+		}
+
+		var token strings.Builder
+		closed := false
+		for i++; i < len(sql); i++ {
+			if sql[i] != c {
+				token.WriteByte(sql[i])
+				continue
+			}
+			if i+1 < len(sql) && sql[i+1] == c {
+				token.WriteByte(c)
+				i++
+				continue
+			}
+			closed = true
+			break
+		}
+		if !closed {
+			return "", nil, fmt.Errorf("unterminated quote in %s", sql)
+		}
+		skeleton.WriteString(string(c) + "?" + string(c))
+		tokens = append(tokens, token.String())
+	}
+	return skeleton.String(), tokens, nil
+}
+
+func buildAdversarialQuery(t *testing.T, input string) string {
+	model := map[string]interface{}{
+		"table": input,
+		"select": []interface{}{
+			[]interface{}{
+				map[string]interface{}{"type": "field", "params": []string{input}},
+				map[string]interface{}{"type": "avg"},
+				map[string]interface{}{"type": "alias", "params": []string{input}},
+			},
+		},
+		"tags": []interface{}{
+			map[string]interface{}{"key": input, "operator": "=", "value": input},
+			map[string]interface{}{"key": input, "operator": "!=", "value": input, "condition": "OR"},
+			map[string]interface{}{"key": input, "operator": "LIKE", "value": input},
+			map[string]interface{}{"key": input, "operator": "!~", "value": input},
+		},
+		"groupBy": []interface{}{
+			map[string]interface{}{"type": "time", "params": []string{"1 minute"}},
+			map[string]interface{}{"type": "tag", "params": []string{input}},
+		},
+		"orderByTime": "ASC",
+	}
+	requestJson, err := json.Marshal(model)
+	require.NoError(t, err)
+
+	var query QueryModel
+	require.NoError(t, json.Unmarshal(requestJson, &query))
+	require.NoError(t, query.Introspect())
+	sql, err := query.Build(&backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			JSON: requestJson,
+			TimeRange: backend.TimeRange{
+				From: time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2022, 10, 10, 6, 0, 0, 0, time.UTC),
+			},
+		}},
+	})
+	require.NoError(t, err)
+	return sql
+}
+
+func TestBuildAdversarialInputs(t *testing.T) {
+	expected, _, err := tokenizeSQL(buildAdversarialQuery(t, "x"))
+	require.NoError(t, err)
+
+	for _, input := range adversarialInputs {
+		sql := buildAdversarialQuery(t, input)
+		skeleton, tokens, err := tokenizeSQL(sql)
+		require.NoError(t, err, sql)
+
+		// Whatever the input is, it only changes the contents of identifiers and literals.
+		assert.Equal(t, expected, skeleton, sql)
+		count := 0
+		for _, token := range tokens {
+			if token == input {
+				count++
+			}
+		}
+		// table, field, alias, 4 tag keys, 4 tag values and the tag in GROUP BY, which is selected too.
+		assert.Equal(t, 13, count, sql)
+	}
+}
+
+func TestBuildAdversarialParameters(t *testing.T) {
+	for _, input := range adversarialInputs {
+		query := QueryModel{
+			Table:   "t",
+			Select:  [][]*SelectItem{{{Type: "field", Params: []string{"v"}}, {Type: "avg", Params: []string{input}}}},
+			GroupBy: []*SelectItem{{Type: "time", Params: []string{input}}},
+		}
+		assert.Error(t, query.Introspect(), input)
+
+		query = QueryModel{
+			Table:   "t",
+			Select:  [][]*SelectItem{{{Type: "field", Params: []string{"v"}}}},
+			Tags:    []*TagItem{{Key: "k", Operator: input, Value: "v"}},
+			Limit:   input,
+			GroupBy: []*SelectItem{{Type: "time", Params: []string{"1m", input}}},
+		}
+		assert.Error(t, query.Introspect(), input)
+	}
+
+	for _, query := range []QueryModel{
+		{Tags: []*TagItem{{Key: "k", Operator: "= 1 OR", Value: "v"}}},
+		{Tags: []*TagItem{{Key: "k", Value: "v"}, {Key: "k", Value: "v", Condition: "OR 1=1 OR"}}},
+		{OrderByTime: "ASC; DROP TABLE t"},
+		{Limit: "10; DROP TABLE t"},
+		{Limit: "-1"},
+	} {
+		assert.Error(t, query.Introspect())
+	}
+}
@@ -68,6 +68,21 @@ func getRowFillValues(f *data.Frame, tsSchema data.TimeSeriesSchema, currentTime
 // match the intervals of the time-series field in the data.Frame and
 // therefore needs to be resampled.
 func Resample(f *data.Frame, interval time.Duration, timeRange backend.TimeRange, fillMissing *data.FillMissing) (*data.Frame, error) {
+	return ResampleWithOrigin(f, interval, time.Unix(0, 0), timeRange, fillMissing)
+}
+
+// alignTime returns the start of the time bucket containing t, buckets are aligned to origin.
+func alignTime(t time.Time, origin time.Time, interval time.Duration) time.Time {
+	d := t.Sub(origin) % interval
+	if d < 0 {
+		d += interval
+	}
+	return t.Add(-d)
+}
+
+// ResampleWithOrigin resamples provided time-series data.Frame like Resample,
+// with time buckets aligned to origin instead of the Unix epoch.
+func ResampleWithOrigin(f *data.Frame, interval time.Duration, origin time.Time, timeRange backend.TimeRange, fillMissing *data.FillMissing) (*data.Frame, error) {
 	tsSchema := f.TimeSeriesSchema()
 	if tsSchema.Type == data.TimeSeriesTypeNot {
 		return f, fmt.Errorf("can not fill missing, not timeseries frame")
@@ -91,8 +106,7 @@ func Resample(f *data.Frame, interval time.Duration, timeRange backend.TimeRange
 	lastSeenRowIdx := -1
 	timeField := f.Fields[tsSchema.TimeIndex]
 
-	startUnixTime := timeRange.From.Unix() / int64(interval.Seconds()) * int64(interval.Seconds())
-	startTime := time.Unix(startUnixTime, 0)
+	startTime := alignTime(timeRange.From, origin, interval)
 
 	for currentTime := startTime; !currentTime.After(timeRange.To); currentTime = currentTime.Add(interval) {
 		initialRowIdx := 0
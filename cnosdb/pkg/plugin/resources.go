@@ -0,0 +1,320 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+const (
+	// SCHEMA_CACHE_TTL is how long the responses of the resources are cached.
+	SCHEMA_CACHE_TTL      = 30 * time.Second
+	SCHEMA_CACHE_MAX_SIZE = 8 << 20
+	// TAG_VALUES_LIMIT is the maximum number of values returned by the tag values resource.
+	TAG_VALUES_LIMIT = 1000
+)
+
+// Kinds of the columns of a table.
+const (
+	COLUMN_KIND_TIME  = "time"
+	COLUMN_KIND_TAG   = "tag"
+	COLUMN_KIND_FIELD = "field"
+)
+
+// Column is a column of a table, as returned by the columns resource.
+type Column struct {
+	Name string `json:"name"`
+	// Kind is either time, tag or field.
+	Kind string `json:"kind"`
+	// Type is the data type of the column in CnosDB, such as BIGINT or STRING.
+	Type string `json:"type"`
+}
+
+// newResourceHandler routes the resources of the datasource:
+//   - GET /databases: the names of the databases.
+//   - GET /tables: the names of the tables of the database.
+//   - GET /tables/{table}/columns: the columns of a table.
+//   - GET /tables/{table}/tags/{key}/values?filter=: the values of a tag containing filter.
+func (d *CnosDatasource) newResourceHandler() backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/databases", d.handleDatabases)
+	mux.HandleFunc("/tables", d.handleTables)
+	mux.HandleFunc("/tables/", d.handleTable)
+	return httpadapter.New(mux)
+}
+
+func (d *CnosDatasource) handleDatabases(w http.ResponseWriter, r *http.Request) {
+	auth, ok := allowGet(w, r)
+	if !ok {
+		return
+	}
+	rows, err := d.querySchema(r.Context(), auth, "SHOW DATABASES")
+	if err != nil {
+		writeResourceError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResource(w, rowNames(rows, "Database", "database_name"))
+}
+
+func (d *CnosDatasource) handleTables(w http.ResponseWriter, r *http.Request) {
+	auth, ok := allowGet(w, r)
+	if !ok {
+		return
+	}
+	tables, err := d.tables(r.Context(), auth)
+	if err != nil {
+		writeResourceError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeResource(w, tables)
+}
+
+func (d *CnosDatasource) handleTable(w http.ResponseWriter, r *http.Request) {
+	auth, ok := allowGet(w, r)
+	if !ok {
+		return
+	}
+	// Names are escaped in their segment of the path, they may contain "/".
+	var parts []string
+	for _, segment := range strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/tables/"), "/") {
+		part, err := url.PathUnescape(segment)
+		if err != nil {
+			writeResourceError(w, http.StatusBadRequest, fmt.Errorf("invalid resource %q: %s", r.URL.Path, err))
+			return
+		}
+		parts = append(parts, part)
+	}
+	switch {
+	case len(parts) == 2 && parts[0] != "" && parts[1] == "columns":
+		columns, err := d.tableColumns(r.Context(), auth, parts[0])
+		if err != nil {
+			writeResourceError(w, http.StatusBadGateway, err)
+			return
+		}
+		writeResource(w, columns)
+	case len(parts) == 4 && parts[0] != "" && parts[1] == "tags" && parts[2] != "" && parts[3] == "values":
+		values, err := d.tagValues(r.Context(), auth, parts[0], parts[2], r.URL.Query().Get("filter"))
+		if err != nil {
+			writeResourceError(w, http.StatusBadGateway, err)
+			return
+		}
+		writeResource(w, values)
+	default:
+		writeResourceError(w, http.StatusNotFound, fmt.Errorf("unknown resource %q", r.URL.Path))
+	}
+}
+
+// tables returns the names of the tables of the database.
+func (d *CnosDatasource) tables(ctx context.Context, auth string) ([]string, error) {
+	rows, err := d.querySchema(ctx, auth, "SHOW TABLES")
+	if err != nil {
+		return nil, err
+	}
+	return rowNames(rows, "Table", "table_name"), nil
+}
+
+// tableColumns returns the columns of table.
+func (d *CnosDatasource) tableColumns(ctx context.Context, auth string, table string) ([]Column, error) {
+	rows, err := d.querySchema(ctx, auth, "DESCRIBE TABLE "+QuoteIdentifier(table))
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]Column, 0, len(rows))
+	for _, row := range rows {
+		column := Column{
+			Name: rowString(row, "FIELDNAME", "COLUMN_NAME"),
+			Type: rowString(row, "TYPE", "DATA_TYPE"),
+		}
+		if column.Name == "" {
+			continue
+		}
+		switch kind := strings.ToLower(rowString(row, "COLUMN_TYPE")); {
+		case kind != "":
+			column.Kind = kind
+		case row["ISTAG"] == true:
+			column.Kind = COLUMN_KIND_TAG
+		case column.Name == "time":
+			column.Kind = COLUMN_KIND_TIME
+		default:
+			column.Kind = COLUMN_KIND_FIELD
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// tagValues returns the distinct values of the tag key of table which contain filter.
+func (d *CnosDatasource) tagValues(ctx context.Context, auth string, table string, key string, filter string) ([]string, error) {
+	sql := fmt.Sprintf("SELECT DISTINCT %s FROM %s", QuoteIdentifier(key), QuoteIdentifier(table))
+	if filter != "" {
+		sql += fmt.Sprintf(" WHERE %s LIKE %s", QuoteIdentifier(key), QuoteString("%"+escapeLike(filter)+"%"))
+	}
+	sql += fmt.Sprintf(" ORDER BY %s LIMIT %d", QuoteIdentifier(key), TAG_VALUES_LIMIT)
+	rows, err := d.querySchema(ctx, auth, sql)
+	if err != nil {
+		return nil, err
+	}
+	return rowNames(rows, key), nil
+}
+
+// datasourceSchema is the SchemaSource of queries, it shares the cache of the resources.
+type datasourceSchema struct {
+	ctx  context.Context
+	d    *CnosDatasource
+	auth string
+}
+
+func (s *datasourceSchema) Tables() ([]string, error) {
+	tables, err := s.d.tables(s.ctx, s.auth)
+	if err != nil {
+		log.DefaultLogger.Warn("Failed to load the tables to validate the query", "err", err)
+	}
+	return tables, err
+}
+
+func (s *datasourceSchema) Columns(table string) ([]Column, error) {
+	columns, err := s.d.tableColumns(s.ctx, s.auth, table)
+	if err != nil {
+		log.DefaultLogger.Warn("Failed to load the columns to validate the query", "table", table, "err", err)
+	}
+	return columns, err
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// resourceAuth returns the stored credentials of the datasource of a resource request.
+func resourceAuth(r *http.Request) (string, error) {
+	settings := httpadapter.PluginConfigFromContext(r.Context()).DataSourceInstanceSettings
+	if settings == nil {
+		return "", fmt.Errorf("missing datasource settings")
+	}
+	auth, exists := settings.DecryptedSecureJSONData["auth"]
+	if !exists {
+		return "", fmt.Errorf("cannot get secure json data 'auth'")
+	}
+	return auth, nil
+}
+
+// querySchema sends sql to CnosDB with the credentials auth and decodes the rows of the
+// response, which is cached for SCHEMA_CACHE_TTL.
+func (d *CnosDatasource) querySchema(ctx context.Context, auth string, sql string) ([]map[string]interface{}, error) {
+	log.DefaultLogger.Debug("CnosDB schema query", "sql", sql)
+
+	ctx, cancel := withQueryTimeout(ctx, d.defaultQueryTimeout())
+	defer cancel()
+	value, _, err := d.schemaCache.fetch(ctx, sql, cacheQuery{
+		run: func(ctx context.Context) (interface{}, []byte, error) {
+			res, err := d.execute(ctx, auth, sql)
+			if err != nil {
+				return nil, nil, err
+			}
+			defer closeBody(res)
+			// One byte more than the maximum size, so that a truncated response is not decoded.
+			body, err := io.ReadAll(io.LimitReader(res, d.maxResponseSize()+1))
+			if err != nil {
+				return nil, nil, err
+			}
+			if int64(len(body)) > d.maxResponseSize() {
+				return nil, nil, fmt.Errorf("response too large: the response of %q exceeds the maximum size of %d bytes",
+					sql, d.maxResponseSize())
+			}
+			rows, err := decodeSchemaRows(sql, body)
+			return rows, body, err
+		},
+		decode: func(body []byte) (interface{}, error) {
+			return decodeSchemaRows(sql, body)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]map[string]interface{}), nil
+}
+
+// decodeSchemaRows decodes the rows of the response of sql, numbers are kept as they are
+// written, tag values are returned as strings.
+func decodeSchemaRows(sql string, body []byte) ([]map[string]interface{}, error) {
+	var rows []map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&rows); err != nil {
+		return nil, fmt.Errorf("failed to decode the response of %q: %s", sql, err)
+	}
+	return rows, nil
+}
+
+// rowString returns the first of keys which is a string in row.
+func rowString(row map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := row[key].(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// rowNames returns the value of the first of keys in each row, or the only value of rows with one column.
+func rowNames(rows []map[string]interface{}, keys ...string) []string {
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		var value interface{}
+		for _, key := range keys {
+			if v, ok := row[key]; ok {
+				value = v
+				break
+			}
+		}
+		if value == nil && len(row) == 1 {
+			for _, v := range row {
+				value = v
+			}
+		}
+		if value != nil {
+			names = append(names, fmt.Sprint(value))
+		}
+	}
+	return names
+}
+
+// allowGet checks the method of a resource request and returns its credentials,
+// it writes the error response if it fails.
+func allowGet(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet {
+		writeResourceError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return "", false
+	}
+	auth, err := resourceAuth(r)
+	if err != nil {
+		writeResourceError(w, http.StatusInternalServerError, err)
+		return "", false
+	}
+	return auth, true
+}
+
+func writeResource(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.DefaultLogger.Error("Failed to write resource", "err", err)
+	}
+}
+
+func writeResourceError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); err != nil {
+		log.DefaultLogger.Error("Failed to write resource error", "err", err)
+	}
+}
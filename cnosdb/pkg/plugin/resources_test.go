@@ -0,0 +1,154 @@
+package plugin_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resourceSender struct {
+	res *backend.CallResourceResponse
+}
+
+func (s *resourceSender) Send(res *backend.CallResourceResponse) error {
+	s.res = res
+	return nil
+}
+
+func TestCallResource(t *testing.T) {
+	var sqls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sql := string(body)
+		sqls = append(sqls, sql)
+		switch {
+		case sql == "SHOW DATABASES":
+			_, _ = w.Write([]byte(`[{"Database":"public"},{"Database":"usage_schema"}]`))
+		case sql == "SHOW TABLES":
+			_, _ = w.Write([]byte(`[{"Table":"cpu"},{"Table":"mem"}]`))
+		case sql == `DESCRIBE TABLE "cpu"`:
+			_, _ = w.Write([]byte(`[{"FIELDNAME":"time","TYPE":"TIMESTAMP(NANOSECOND)","ISTAG":false},` +
+				`{"FIELDNAME":"host","TYPE":"STRING","ISTAG":true},{"FIELDNAME":"usage","TYPE":"DOUBLE","ISTAG":false}]`))
+		case sql == `DESCRIBE TABLE "a/b"`:
+			_, _ = w.Write([]byte(`[{"FIELDNAME":"time","TYPE":"TIMESTAMP(NANOSECOND)","ISTAG":false}]`))
+		case strings.HasPrefix(sql, `SELECT DISTINCT "host" FROM "cpu"`):
+			_, _ = w.Write([]byte(`[{"host":"a_1"},{"host":"b"}]`))
+		default:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"error_code":"010001","error_message":"unknown table"}`))
+		}
+	}))
+	defer server.Close()
+
+	ds := newTestDatasource(t, server.URL, `{}`)
+	call := func(method string, path string) *backend.CallResourceResponse {
+		sender := &resourceSender{}
+		err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: newTestPluginContext(),
+			Path:          strings.SplitN(path, "?", 2)[0],
+			Method:        method,
+			URL:           path,
+		}, sender)
+		require.NoError(t, err)
+		require.NotNil(t, sender.res)
+		return sender.res
+	}
+
+	res := call("GET", "databases")
+	assert.Equal(t, http.StatusOK, res.Status)
+	assert.JSONEq(t, `["public","usage_schema"]`, string(res.Body))
+
+	res = call("GET", "tables")
+	assert.JSONEq(t, `["cpu","mem"]`, string(res.Body))
+
+	res = call("GET", "tables/cpu/columns")
+	assert.Equal(t, http.StatusOK, res.Status)
+	assert.JSONEq(t, `[{"name":"time","kind":"time","type":"TIMESTAMP(NANOSECOND)"},`+
+		`{"name":"host","kind":"tag","type":"STRING"},{"name":"usage","kind":"field","type":"DOUBLE"}]`, string(res.Body))
+
+	res = call("GET", "tables/cpu/tags/host/values?filter=a_")
+	assert.JSONEq(t, `["a_1","b"]`, string(res.Body))
+	assert.Equal(t, `SELECT DISTINCT "host" FROM "cpu" WHERE "host" LIKE '%a\_%' ORDER BY "host" LIMIT 1000`, sqls[len(sqls)-1])
+
+	// Responses are cached.
+	n := len(sqls)
+	call("GET", "tables")
+	call("GET", "tables/cpu/columns")
+	assert.Equal(t, n, len(sqls))
+
+	res = call("GET", "tables/disk/columns")
+	assert.Equal(t, http.StatusBadGateway, res.Status)
+	assert.Contains(t, string(res.Body), "unknown table")
+
+	res = call("GET", "tables/cpu/fields")
+	assert.Equal(t, http.StatusNotFound, res.Status)
+
+	// Names containing "/" are escaped.
+	res = call("GET", "tables/a%2Fb/columns")
+	assert.Equal(t, http.StatusOK, res.Status)
+	assert.JSONEq(t, `[{"name":"time","kind":"time","type":"TIMESTAMP(NANOSECOND)"}]`, string(res.Body))
+
+	res = call("POST", "tables")
+	assert.Equal(t, http.StatusMethodNotAllowed, res.Status)
+
+	// Responses larger than the maximum size are not decoded.
+	ds = newTestDatasource(t, server.URL, `{"maxResponseSize":16}`)
+	res = call("GET", "tables")
+	assert.Equal(t, http.StatusBadGateway, res.Status)
+	assert.Contains(t, string(res.Body), "response too large")
+}
+
+func TestQueryDataValidateSchema(t *testing.T) {
+	var sqls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sql := string(body)
+		sqls = append(sqls, sql)
+		switch sql {
+		case "SHOW TABLES":
+			_, _ = w.Write([]byte(`[{"Table":"cpu"}]`))
+		case `DESCRIBE TABLE "cpu"`:
+			_, _ = w.Write([]byte(`[{"FIELDNAME":"time","TYPE":"TIMESTAMP(NANOSECOND)","ISTAG":false},` +
+				`{"FIELDNAME":"host","TYPE":"STRING","ISTAG":true},{"FIELDNAME":"usage","TYPE":"DOUBLE","ISTAG":false}]`))
+		default:
+			_, _ = w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	ds := newTestDatasource(t, server.URL, `{"validateSchema":true}`)
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: newTestPluginContext(),
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{"table":"cpu","select":[[{"type":"field","params":["usage"]},{"type":"avg"}]]}`)},
+			{RefID: "B", JSON: []byte(`{"table":"cpu","select":[[{"type":"field","params":["usag"]},{"type":"avg"}]],` +
+				`"tags":[{"key":"hots","operator":"=","value":"a"}]}`)},
+		},
+	})
+	require.NoError(t, err)
+	assert.NoError(t, resp.Responses["A"].Error)
+	require.Error(t, resp.Responses["B"].Error)
+	assert.Equal(t, `invalid query: unknown column "usag" in table "cpu", did you mean "usage"?; `+
+		`unknown column "hots" in table "cpu", did you mean "host"?`, resp.Responses["B"].Error.Error())
+	// The problems are also sent as the custom metadata of a frame.
+	require.Len(t, resp.Responses["B"].Frames, 1)
+	custom, err := json.Marshal(resp.Responses["B"].Frames[0].Meta.Custom)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"schemaProblems":[`+
+		`{"kind":"unknown_column","name":"usag","message":"unknown column \"usag\" in table \"cpu\"","suggestion":"usage"},`+
+		`{"kind":"unknown_column","name":"hots","message":"unknown column \"hots\" in table \"cpu\"","suggestion":"host"}]}`,
+		string(custom))
+
+	// The invalid query is not sent to CnosDB.
+	for _, sql := range sqls {
+		assert.NotContains(t, sql, "usag\"")
+	}
+}
@@ -1,13 +1,416 @@
 package plugin
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
 type ResponseRow struct {
 	Time   string  `json:"time,omitempty"`
 	Metric string  `json:"metric,omitempty"`
 	Value  float64 `json:"value,omitempty"`
 }
 
-type Array struct {
-	stringArray  []*string
-	float64Array []*float64
-	boolArray    []*bool
+// errResponseTooLarge is returned by sizeLimitedReader once more than the allowed bytes are read.
+var errResponseTooLarge = errors.New("response too large")
+
+type sizeLimitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, errResponseTooLarge
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+type responseValue struct {
+	column string
+	value  interface{}
+}
+
+type responseColumn struct {
+	name string
+	// field is nil until the first non-null value of the column appears.
+	field *data.Field
+	// coerced is set if values of the column are converted to strings.
+	coerced bool
+	// lossy is set if integers of the column are converted to floats with a loss of precision.
+	lossy bool
+}
+
+// frameBuilder appends the rows of a CnosDB response into typed fields of a data.Frame.
+type frameBuilder struct {
+	rows    int
+	times   []time.Time
+	columns map[string]*responseColumn
+	// order is the order of the columns in the response, which is also the order of the frame's fields.
+	order []*responseColumn
+}
+
+func newFrameBuilder() *frameBuilder {
+	return &frameBuilder{
+		columns: make(map[string]*responseColumn),
+	}
+}
+
+func (b *frameBuilder) appendRow(row []responseValue) error {
+	var rowTime time.Time
+	var prev *responseColumn
+	for _, v := range row {
+		if v.column == "time" {
+			timeStr, ok := v.value.(string)
+			if !ok {
+				return fmt.Errorf("unexpected time value %v", v.value)
+			}
+			// CnosDB returns UTC timestamps without an offset, whatever the time zone of the query.
+			parsedTime, err := ParseTimeString(timeStr)
+			if err != nil {
+				log.DefaultLogger.Error("Failed to convert to time", "err", err)
+				return err
+			}
+			rowTime = parsedTime
+			continue
+		}
+
+		col, ok := b.columns[v.column]
+		if !ok {
+			col = &responseColumn{name: v.column}
+			b.columns[v.column] = col
+			b.insertColumn(col, prev)
+		}
+		prev = col
+
+		if v.value == nil {
+			// Null values are appended when the row is finished.
+			continue
+		}
+		b.appendValue(col, v.value)
+	}
+
+	b.times = append(b.times, rowTime)
+	b.rows++
+	// Columns missing in this row get a null value.
+	for _, col := range b.order {
+		if col.field != nil && col.field.Len() < b.rows {
+			col.field.Append(nil)
+		}
+	}
+	return nil
+}
+
+// insertColumn inserts a new column right after the column preceding it in the
+// current row. CnosDB omits null values from rows, so a column may first appear
+// in a later row, but every row keeps the column order of the SELECT list.
+func (b *frameBuilder) insertColumn(col *responseColumn, prev *responseColumn) {
+	pos := 0
+	for i, c := range b.order {
+		if c == prev {
+			pos = i + 1
+			break
+		}
+	}
+	b.order = append(b.order, nil)
+	copy(b.order[pos+1:], b.order[pos:])
+	b.order[pos] = col
+}
+
+func (b *frameBuilder) appendValue(col *responseColumn, value interface{}) {
+	valueType, ok := fieldTypeOf(value)
+	if !ok {
+		log.DefaultLogger.Error("Unexpected value type", "value", value, "value_type", typeof(value))
+		return
+	}
+
+	if col.field == nil {
+		col.field = data.NewFieldFromFieldType(valueType, b.rows)
+		col.field.Name = col.name
+	} else if valueType != col.field.Type() {
+		// Numbers of a column are stored in the narrowest type holding all of them,
+		// values of different kinds, e.g. numbers and strings, are all kept as strings.
+		widened, ok := widenNumberType(col.field.Type(), valueType)
+		if !ok {
+			widened = data.FieldTypeNullableString
+			col.coerced = true
+		}
+		if widened != col.field.Type() && !col.convert(widened) {
+			col.convert(data.FieldTypeNullableFloat64)
+		}
+	}
+
+	converted, ok := convertValue(value, col.field.Type())
+	if !ok {
+		// A negative number in an unsigned column.
+		col.convert(data.FieldTypeNullableFloat64)
+		converted, _ = convertValue(value, col.field.Type())
+	}
+	if col.field.Type() == data.FieldTypeNullableFloat64 && losesPrecision(value) {
+		col.lossy = true
+	}
+	col.field.Append(converted)
+}
+
+// convert converts all values of the column to fieldType, the column is unchanged if any value can not be converted.
+func (col *responseColumn) convert(fieldType data.FieldType) bool {
+	field := data.NewFieldFromFieldType(fieldType, col.field.Len())
+	field.Name = col.name
+	lossy := false
+	for i := 0; i < col.field.Len(); i++ {
+		val, ok := col.field.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		converted, ok := convertValue(val, fieldType)
+		if !ok {
+			return false
+		}
+		if fieldType == data.FieldTypeNullableFloat64 && losesPrecision(val) {
+			lossy = true
+		}
+		field.Set(i, converted)
+	}
+	col.lossy = col.lossy || lossy
+	col.field = field
+	return true
+}
+
+func (col *responseColumn) notice() (data.Notice, bool) {
+	switch {
+	case col.coerced:
+		return data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Column %q has values of different types, all of them are converted to strings", col.name),
+		}, true
+	case col.lossy:
+		return data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Column %q mixes integers and floats, some integers lost precision when converted to floats", col.name),
+		}, true
+	default:
+		return data.Notice{}, false
+	}
+}
+
+func (b *frameBuilder) frame() *data.Frame {
+	frame := data.NewFrame("response", data.NewField("time", nil, b.times))
+	for _, col := range b.order {
+		if col.field == nil {
+			// The type of a column with only null values is unknown.
+			col.field = data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, b.rows)
+			col.field.Name = col.name
+		}
+		frame.Fields = append(frame.Fields, col.field)
+		if notice, ok := col.notice(); ok {
+			frame.AppendNotices(notice)
+		}
+	}
+	return frame
+}
+
+// fieldTypeOf returns the nullable field type storing a decoded JSON value.
+func fieldTypeOf(value interface{}) (data.FieldType, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		return numberFieldType(v), true
+	case string:
+		return data.FieldTypeNullableString, true
+	case bool:
+		return data.FieldTypeNullableBool, true
+	default:
+		return data.FieldTypeUnknown, false
+	}
+}
+
+// numberFieldType returns the narrowest field type holding a JSON number without losing
+// precision: integers are stored as int64, or uint64 if they are above math.MaxInt64.
+func numberFieldType(num json.Number) data.FieldType {
+	str := num.String()
+	if !strings.ContainsAny(str, ".eE") {
+		if _, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return data.FieldTypeNullableInt64
+		}
+		if _, err := strconv.ParseUint(str, 10, 64); err == nil {
+			return data.FieldTypeNullableUint64
+		}
+	}
+	return data.FieldTypeNullableFloat64
+}
+
+// widenNumberType returns the field type holding numbers of both field types.
+func widenNumberType(a data.FieldType, b data.FieldType) (data.FieldType, bool) {
+	rank := map[data.FieldType]int{
+		data.FieldTypeNullableInt64:   0,
+		data.FieldTypeNullableUint64:  1,
+		data.FieldTypeNullableFloat64: 2,
+	}
+	rankA, okA := rank[a]
+	rankB, okB := rank[b]
+	if !okA || !okB {
+		return data.FieldTypeUnknown, false
+	}
+	if rankA > rankB {
+		return a, true
+	}
+	return b, true
+}
+
+// convertValue converts a decoded JSON value, or a concrete value of a numeric field,
+// to a value of the nullable fieldType.
+func convertValue(value interface{}, fieldType data.FieldType) (interface{}, bool) {
+	var str string
+	switch v := value.(type) {
+	case json.Number:
+		str = v.String()
+	case int64:
+		str = strconv.FormatInt(v, 10)
+	case uint64:
+		str = strconv.FormatUint(v, 10)
+	case float64:
+		str = strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		if fieldType != data.FieldTypeNullableString {
+			return nil, false
+		}
+		return &v, true
+	case bool:
+		str = strconv.FormatBool(v)
+		if fieldType != data.FieldTypeNullableString {
+			return &v, fieldType == data.FieldTypeNullableBool
+		}
+	default:
+		return nil, false
+	}
+
+	switch fieldType {
+	case data.FieldTypeNullableString:
+		return &str, true
+	case data.FieldTypeNullableInt64:
+		n, err := strconv.ParseInt(str, 10, 64)
+		return &n, err == nil
+	case data.FieldTypeNullableUint64:
+		n, err := strconv.ParseUint(str, 10, 64)
+		return &n, err == nil
+	case data.FieldTypeNullableFloat64:
+		n, err := strconv.ParseFloat(str, 64)
+		return &n, err == nil
+	default:
+		return nil, false
+	}
+}
+
+// losesPrecision reports whether an integer value changes when it is converted to float64.
+func losesPrecision(value interface{}) bool {
+	switch v := value.(type) {
+	case json.Number:
+		if numberFieldType(v) == data.FieldTypeNullableFloat64 {
+			return false
+		}
+		if n, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			return losesPrecision(n)
+		}
+		n, _ := strconv.ParseUint(v.String(), 10, 64)
+		return losesPrecision(n)
+	case int64:
+		f := float64(v)
+		return f >= math.MaxInt64 || int64(f) != v
+	case uint64:
+		f := float64(v)
+		return f >= math.MaxUint64 || uint64(f) != v
+	default:
+		return false
+	}
+}
+
+// DecodeResponse decodes a CnosDB JSON response, an array of row objects, into a data.Frame.
+// Rows are decoded one by one and appended into the frame's fields, so the response is never
+// held in memory as a whole. If the response is larger than maxSize bytes, decoding stops and
+// the rows decoded so far are returned with a warning notice. Timestamps without a time zone
+// offset are parsed as UTC.
+func DecodeResponse(body io.Reader, maxSize int64) (*data.Frame, error) {
+	builder := newFrameBuilder()
+	dec := json.NewDecoder(&sizeLimitedReader{reader: body, remaining: maxSize})
+	// Keep numbers as literals, so that 64-bit integers don't lose precision.
+	dec.UseNumber()
+
+	err := decodeRows(dec, builder)
+	if errors.Is(err, errResponseTooLarge) {
+		frame := builder.frame()
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf("Response exceeded the maximum size of %d bytes, only the first %d rows are shown",
+				maxSize, builder.rows),
+		})
+		return frame, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return builder.frame(), nil
+}
+
+func decodeRows(dec *json.Decoder, builder *frameBuilder) error {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		// Empty response.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("unexpected response, expected an array of rows but got %v", tok)
+	}
+
+	var row []responseValue
+	for dec.More() {
+		row = row[:0]
+		if tok, err = dec.Token(); err != nil {
+			return err
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+			return fmt.Errorf("unexpected response, expected a row object but got %v", tok)
+		}
+		for dec.More() {
+			if tok, err = dec.Token(); err != nil {
+				return err
+			}
+			column, ok := tok.(string)
+			if !ok {
+				return fmt.Errorf("unexpected response, expected a column name but got %v", tok)
+			}
+			var value interface{}
+			if err = dec.Decode(&value); err != nil {
+				return err
+			}
+			row = append(row, responseValue{column: column, value: value})
+		}
+		// Consume the closing '}' before the row is appended, a row cut off
+		// by the size limit is dropped.
+		if _, err = dec.Token(); err != nil {
+			return err
+		}
+		if err = builder.appendRow(row); err != nil {
+			return err
+		}
+	}
+
+	_, err = dec.Token()
+	return err
 }
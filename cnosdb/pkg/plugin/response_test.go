@@ -0,0 +1,190 @@
+package plugin_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cnosdb/cnosdb-grafana-datasource-backend/pkg/plugin"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	body := `[
+		{"time":"2022-10-10 00:00:00","fa":1.5,"ta":"a"},
+		{"time":"2022-10-10 00:01:00","fa":2.5,"fb":true},
+		{"time":"2022-10-10 00:02:00","ta":"c"}
+	]`
+	frame, err := plugin.DecodeResponse(strings.NewReader(body), 1<<20)
+	require.NoError(t, err)
+
+	require.Equal(t, 3, frame.Rows())
+	require.Len(t, frame.Fields, 4)
+	assert.Equal(t, time.Date(2022, 10, 10, 0, 1, 0, 0, time.UTC), frame.Fields[0].At(1))
+
+	fa := frame.Fields[1]
+	assert.Equal(t, data.FieldTypeNullableFloat64, fa.Type())
+	v, ok := fa.ConcreteAt(1)
+	assert.True(t, ok)
+	assert.Equal(t, 2.5, v)
+	_, ok = fa.ConcreteAt(2)
+	assert.False(t, ok)
+
+	ta := frame.Fields[3]
+	assert.Equal(t, data.FieldTypeNullableString, ta.Type())
+	_, ok = ta.ConcreteAt(1)
+	assert.False(t, ok)
+
+	fb := frame.Fields[2]
+	assert.Equal(t, data.FieldTypeNullableBool, fb.Type())
+	_, ok = fb.ConcreteAt(0)
+	assert.False(t, ok)
+}
+
+func TestDecodeEmptyResponse(t *testing.T) {
+	for _, body := range []string{"", "[]"} {
+		frame, err := plugin.DecodeResponse(strings.NewReader(body), 1<<20)
+		require.NoError(t, err)
+		assert.Equal(t, 0, frame.Rows())
+	}
+}
+
+func TestDecodeResponseTooLarge(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("[")
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(`{"time":"2022-10-10 00:00:00","fa":1}`)
+	}
+	sb.WriteString("]")
+
+	frame, err := plugin.DecodeResponse(strings.NewReader(sb.String()), 4096)
+	require.NoError(t, err)
+
+	assert.Greater(t, frame.Rows(), 0)
+	assert.Less(t, frame.Rows(), 1000)
+	for _, field := range frame.Fields {
+		assert.Equal(t, frame.Rows(), field.Len())
+	}
+	require.NotNil(t, frame.Meta)
+	require.Len(t, frame.Meta.Notices, 1)
+	assert.Equal(t, data.NoticeSeverityWarning, frame.Meta.Notices[0].Severity)
+}
+
+func TestDecodeInvalidResponse(t *testing.T) {
+	_, err := plugin.DecodeResponse(strings.NewReader(`{"error_code":"010001"}`), 1<<20)
+	assert.Error(t, err)
+}
+
+func TestDecodeResponseFieldOrder(t *testing.T) {
+	// Null values are omitted from rows, so "fb" and "fd" first appear in later rows.
+	body := `[
+		{"time":"2022-10-10 00:00:00","fa":1,"fc":"x"},
+		{"time":"2022-10-10 00:01:00","fa":2,"fb":3,"fc":"y"},
+		{"time":"2022-10-10 00:02:00","fc":"z","fd":false},
+		{"time":"2022-10-10 00:03:00","fz":1,"fa":4}
+	]`
+	for i := 0; i < 10; i++ {
+		frame, err := plugin.DecodeResponse(strings.NewReader(body), 1<<20)
+		require.NoError(t, err)
+
+		var names []string
+		for _, field := range frame.Fields {
+			names = append(names, field.Name)
+		}
+		assert.Equal(t, []string{"time", "fz", "fa", "fb", "fc", "fd"}, names)
+	}
+}
+
+func TestDecodeResponseNumberPrecision(t *testing.T) {
+	body := `[
+		{"time":"2022-10-10 00:00:00","i":9223372036854775807,"u":1,"f":1.5,"s":9007199254740993},
+		{"time":"2022-10-10 00:01:00","i":-9223372036854775808,"u":18446744073709551615,"f":1.7976931348623157e308,"s":-9007199254740993}
+	]`
+	frame, err := plugin.DecodeResponse(strings.NewReader(body), 1<<20)
+	require.NoError(t, err)
+	require.Len(t, frame.Fields, 5)
+
+	i := frame.Fields[1]
+	assert.Equal(t, data.FieldTypeNullableInt64, i.Type())
+	assert.Equal(t, int64(math.MaxInt64), *i.At(0).(*int64))
+	assert.Equal(t, int64(math.MinInt64), *i.At(1).(*int64))
+
+	u := frame.Fields[2]
+	assert.Equal(t, data.FieldTypeNullableUint64, u.Type())
+	assert.Equal(t, uint64(1), *u.At(0).(*uint64))
+	assert.Equal(t, uint64(math.MaxUint64), *u.At(1).(*uint64))
+
+	f := frame.Fields[3]
+	assert.Equal(t, data.FieldTypeNullableFloat64, f.Type())
+	assert.Equal(t, 1.5, *f.At(0).(*float64))
+	assert.Equal(t, math.MaxFloat64, *f.At(1).(*float64))
+
+	s := frame.Fields[4]
+	assert.Equal(t, data.FieldTypeNullableInt64, s.Type())
+	assert.Equal(t, int64(1<<53+1), *s.At(0).(*int64))
+	assert.Equal(t, int64(-(1<<53 + 1)), *s.At(1).(*int64))
+}
+
+func TestDecodeResponseNumberWidening(t *testing.T) {
+	body := `[
+		{"time":"2022-10-10 00:00:00","a":1,"b":1},
+		{"time":"2022-10-10 00:01:00","a":2.5,"b":18446744073709551615},
+		{"time":"2022-10-10 00:02:00","b":-1}
+	]`
+	frame, err := plugin.DecodeResponse(strings.NewReader(body), 1<<20)
+	require.NoError(t, err)
+	require.Len(t, frame.Fields, 3)
+
+	a := frame.Fields[1]
+	assert.Equal(t, data.FieldTypeNullableFloat64, a.Type())
+	assert.Equal(t, 1.0, *a.At(0).(*float64))
+	assert.Equal(t, 2.5, *a.At(1).(*float64))
+
+	// A negative value after a value above math.MaxInt64 only fits into float64.
+	b := frame.Fields[2]
+	assert.Equal(t, data.FieldTypeNullableFloat64, b.Type())
+	assert.Equal(t, -1.0, *b.At(2).(*float64))
+}
+
+func TestDecodeResponseMixedTypes(t *testing.T) {
+	body := `[
+		{"time":"2022-10-10 00:00:00","n":null,"m":1,"a":null,"p":9007199254740993},
+		{"time":"2022-10-10 00:01:00","n":null,"m":"x","a":2,"p":0.5},
+		{"time":"2022-10-10 00:02:00","m":true}
+	]`
+	frame, err := plugin.DecodeResponse(strings.NewReader(body), 1<<20)
+	require.NoError(t, err)
+	require.Len(t, frame.Fields, 5)
+
+	// A column with only null values is kept.
+	n := frame.Fields[1]
+	assert.Equal(t, "n", n.Name)
+	assert.Equal(t, 3, n.Len())
+	assert.True(t, n.Nullable())
+
+	m := frame.Fields[2]
+	assert.Equal(t, data.FieldTypeNullableString, m.Type())
+	assert.Equal(t, "1", *m.At(0).(*string))
+	assert.Equal(t, "x", *m.At(1).(*string))
+	assert.Equal(t, "true", *m.At(2).(*string))
+
+	// The type of a column whose first value is null is decided by later values.
+	a := frame.Fields[3]
+	assert.Equal(t, data.FieldTypeNullableInt64, a.Type())
+	assert.Nil(t, a.At(0).(*int64))
+	assert.Equal(t, int64(2), *a.At(1).(*int64))
+
+	p := frame.Fields[4]
+	assert.Equal(t, data.FieldTypeNullableFloat64, p.Type())
+
+	require.NotNil(t, frame.Meta)
+	require.Len(t, frame.Meta.Notices, 2)
+	assert.Contains(t, frame.Meta.Notices[0].Text, `"m"`)
+	assert.Contains(t, frame.Meta.Notices[1].Text, `"p"`)
+}
@@ -0,0 +1,250 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// SchemaSource gives the schema of the database, Introspect checks queries against it if it is set.
+type SchemaSource interface {
+	// Tables returns the names of the tables of the database.
+	Tables() ([]string, error)
+	// Columns returns the columns of a table.
+	Columns(table string) ([]Column, error)
+}
+
+// Kinds of the problems found by checking a query against the schema.
+const (
+	SCHEMA_UNKNOWN_TABLE     = "unknown_table"
+	SCHEMA_UNKNOWN_COLUMN    = "unknown_column"
+	SCHEMA_TAG_AS_FIELD      = "tag_as_field"
+	SCHEMA_NON_NUMERIC_FIELD = "non_numeric_field"
+)
+
+// SchemaProblem is a mistake of a query found by checking it against the schema.
+type SchemaProblem struct {
+	Kind string `json:"kind"`
+	// Name is the name of the table or the column the problem is about.
+	Name    string `json:"name"`
+	Message string `json:"message"`
+	// Suggestion is the known name closest to Name, it is empty if no name is close enough.
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+func (p *SchemaProblem) String() string {
+	if p.Suggestion == "" {
+		return p.Message
+	}
+	return fmt.Sprintf("%s, did you mean %q?", p.Message, p.Suggestion)
+}
+
+// SchemaError lists all the problems of a query found by checking it against the schema.
+type SchemaError struct {
+	Problems []*SchemaProblem `json:"problems"`
+}
+
+// Frame returns an empty frame with the problems in its custom metadata, so that the frontend
+// gets them along with the error.
+func (e *SchemaError) Frame() *data.Frame {
+	frame := data.NewFrame("")
+	frame.Meta = &data.FrameMeta{Custom: map[string]interface{}{"schemaProblems": e.Problems}}
+	return frame
+}
+
+func (e *SchemaError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		messages[i] = p.String()
+	}
+	return "invalid query: " + strings.Join(messages, "; ")
+}
+
+// schemaChecker collects the problems of a query on a table with the given columns.
+type schemaChecker struct {
+	columns  map[string]Column
+	names    []string
+	fields   []string
+	problems []*SchemaProblem
+}
+
+// checkSchema checks the table and the columns of the query against query.Schema. The schema is
+// only used to find mistakes early, the query is left to CnosDB if the schema cannot be loaded.
+func (query *QueryModel) checkSchema() error {
+	if query.Table == "" {
+		return nil
+	}
+	tables, err := query.Schema.Tables()
+	if err != nil {
+		return nil
+	}
+	if !containsString(tables, query.Table) {
+		return &SchemaError{Problems: []*SchemaProblem{{
+			Kind:       SCHEMA_UNKNOWN_TABLE,
+			Name:       query.Table,
+			Message:    fmt.Sprintf("unknown table %q", query.Table),
+			Suggestion: closestName(query.Table, tables),
+		}}}
+	}
+	columns, err := query.Schema.Columns(query.Table)
+	if err != nil {
+		return nil
+	}
+
+	c := &schemaChecker{columns: make(map[string]Column, len(columns))}
+	for _, column := range columns {
+		c.columns[column.Name] = column
+		c.names = append(c.names, column.Name)
+		if column.Kind == COLUMN_KIND_FIELD {
+			c.fields = append(c.fields, column.Name)
+		}
+	}
+	for _, sel := range query.Select {
+		c.checkSelect(query.Table, sel)
+	}
+	for _, s := range query.GroupBy {
+		if s.Type == "tag" {
+			c.column(query.Table, s.Params[0])
+		}
+	}
+	for _, tags := range [][]*TagItem{query.Tags, query.AdhocFilters} {
+		for _, tag := range tags {
+			c.column(query.Table, tag.Key)
+		}
+	}
+	if len(c.problems) > 0 {
+		return &SchemaError{Problems: c.problems}
+	}
+	return nil
+}
+
+// checkSelect checks that the field of a select chain is a field of the table, and that it is
+// a number if a part applied to its values, up to the first aggregate, only takes numbers.
+func (c *schemaChecker) checkSelect(table string, sel []*SelectItem) {
+	for i, s := range sel {
+		if s.Type != "field" || s.Params[0] == "*" {
+			continue
+		}
+		column, ok := c.column(table, s.Params[0])
+		if !ok {
+			continue
+		}
+		if column.Kind == COLUMN_KIND_TAG {
+			c.problems = append(c.problems, &SchemaProblem{
+				Kind:       SCHEMA_TAG_AS_FIELD,
+				Name:       column.Name,
+				Message:    fmt.Sprintf("%q is a tag of table %q, not a field", column.Name, table),
+				Suggestion: closestName(column.Name, c.fields),
+			})
+			continue
+		}
+		if column.Type == "" || isNumericType(column.Type) {
+			continue
+		}
+		for _, part := range sel[i+1:] {
+			if part.Def.Numeric {
+				c.problems = append(c.problems, &SchemaProblem{
+					Kind:    SCHEMA_NON_NUMERIC_FIELD,
+					Name:    column.Name,
+					Message: fmt.Sprintf("%s cannot be applied to field %q of type %s", part.Type, column.Name, column.Type),
+				})
+				break
+			}
+			if part.Def.Aggregate {
+				break
+			}
+		}
+	}
+}
+
+// column returns the column name of the table, it adds a problem if the table has no such column.
+func (c *schemaChecker) column(table string, name string) (Column, bool) {
+	column, ok := c.columns[name]
+	if !ok {
+		c.problems = append(c.problems, &SchemaProblem{
+			Kind:       SCHEMA_UNKNOWN_COLUMN,
+			Name:       name,
+			Message:    fmt.Sprintf("unknown column %q in table %q", name, table),
+			Suggestion: closestName(name, c.names),
+		})
+	}
+	return column, ok
+}
+
+// numericTypes are the names of the numeric data types, without modifiers such as UNSIGNED or a precision.
+var numericTypes = []string{
+	"TINYINT", "SMALLINT", "INT", "INTEGER", "BIGINT",
+	"FLOAT", "DOUBLE", "REAL", "DECIMAL", "NUMERIC",
+}
+
+// isNumericType tells whether a data type of CnosDB, such as BIGINT UNSIGNED or DOUBLE, is a number.
+// Only the name of the type is compared, so that INTERVAL or POINT are not numbers.
+func isNumericType(dataType string) bool {
+	name := strings.FieldsFunc(strings.ToUpper(dataType), func(r rune) bool {
+		return r == ' ' || r == '('
+	})
+	return len(name) > 0 && containsString(numericTypes, name[0])
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// closestName returns the name of names closest to name, ignoring case. It is empty if no name
+// is within an edit distance of a third of the length of name, or 1 for short names.
+func closestName(name string, names []string) string {
+	maxDistance := len([]rune(name)) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	closest, closestDistance := "", maxDistance+1
+	for _, n := range names {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(n)); d < closestDistance {
+			closest, closestDistance = n, d
+		}
+	}
+	return closest
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and transpositions
+// of adjacent characters needed to change a into b.
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(values ...int) int {
+	res := values[0]
+	for _, v := range values[1:] {
+		if v < res {
+			res = v
+		}
+	}
+	return res
+}
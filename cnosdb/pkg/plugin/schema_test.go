@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSchema struct {
+	tables  []string
+	columns []Column
+	err     error
+}
+
+func (s *testSchema) Tables() ([]string, error) {
+	return s.tables, s.err
+}
+
+func (s *testSchema) Columns(table string) ([]Column, error) {
+	return s.columns, s.err
+}
+
+func newTestSchema() *testSchema {
+	return &testSchema{
+		tables: []string{"cpu", "mem"},
+		columns: []Column{
+			{Name: "time", Kind: COLUMN_KIND_TIME, Type: "TIMESTAMP(NANOSECOND)"},
+			{Name: "host", Kind: COLUMN_KIND_TAG, Type: "STRING"},
+			{Name: "usage_idle", Kind: COLUMN_KIND_FIELD, Type: "DOUBLE"},
+			{Name: "status", Kind: COLUMN_KIND_FIELD, Type: "STRING"},
+		},
+	}
+}
+
+func introspectWithSchema(t *testing.T, schema SchemaSource, model string) error {
+	var query QueryModel
+	require.NoError(t, json.Unmarshal([]byte(model), &query))
+	query.Schema = schema
+	return query.Introspect()
+}
+
+func TestIntrospectSchema(t *testing.T) {
+	schema := newTestSchema()
+	require.NoError(t, introspectWithSchema(t, schema, `{"table":"cpu",
+		"select":[[{"type":"field","params":["usage_idle"]},{"type":"avg"}],[{"type":"field","params":["status"]},{"type":"count"}]],
+		"tags":[{"key":"host","operator":"=","value":"a"}],
+		"groupBy":[{"type":"time","params":["1m"]},{"type":"tag","params":["host"]}]}`))
+
+	err := introspectWithSchema(t, schema, `{"table":"cpux","select":[[{"type":"field","params":["usage_idle"]}]]}`)
+	var schemaErr *SchemaError
+	require.True(t, errors.As(err, &schemaErr))
+	require.Len(t, schemaErr.Problems, 1)
+	assert.Equal(t, &SchemaProblem{
+		Kind:       SCHEMA_UNKNOWN_TABLE,
+		Name:       "cpux",
+		Message:    `unknown table "cpux"`,
+		Suggestion: "cpu",
+	}, schemaErr.Problems[0])
+
+	err = introspectWithSchema(t, schema, `{"table":"cpu",
+		"select":[[{"type":"field","params":["usage_idel"]},{"type":"avg"}],[{"type":"field","params":["status"]},{"type":"sum"}],
+			[{"type":"field","params":["host"]}]],
+		"tags":[{"key":"hots","operator":"=","value":"a"}],
+		"adhocFilters":[{"key":"region","operator":"=","value":"eu"}],
+		"groupBy":[{"type":"tag","params":["Host"]}]}`)
+	require.True(t, errors.As(err, &schemaErr))
+	var kinds []string
+	for _, p := range schemaErr.Problems {
+		kinds = append(kinds, p.Kind+":"+p.Name+":"+p.Suggestion)
+	}
+	assert.Equal(t, []string{
+		SCHEMA_UNKNOWN_COLUMN + ":usage_idel:usage_idle",
+		SCHEMA_NON_NUMERIC_FIELD + ":status:",
+		SCHEMA_TAG_AS_FIELD + ":host:",
+		SCHEMA_UNKNOWN_COLUMN + ":Host:host",
+		SCHEMA_UNKNOWN_COLUMN + ":hots:host",
+		SCHEMA_UNKNOWN_COLUMN + ":region:",
+	}, kinds)
+	assert.Contains(t, err.Error(), `unknown column "usage_idel" in table "cpu", did you mean "usage_idle"?`)
+	assert.Contains(t, err.Error(), `sum cannot be applied to field "status" of type STRING`)
+
+	// The parts up to the first aggregate are checked, including the parts inserted as inputs.
+	assert.NoError(t, introspectWithSchema(t, schema, `{"table":"cpu","select":[
+		[{"type":"field","params":["status"]},{"type":"distinct"},{"type":"count"}],
+		[{"type":"field","params":["status"]},{"type":"count"},{"type":"math","params":["* 2"]}]]}`))
+	err = introspectWithSchema(t, schema, `{"table":"cpu","select":[[{"type":"field","params":["status"]},{"type":"rate"}]]}`)
+	require.True(t, errors.As(err, &schemaErr))
+	assert.Equal(t, `invalid query: gauge_agg cannot be applied to field "status" of type STRING`, err.Error())
+	frame := schemaErr.Frame()
+	assert.Equal(t, schemaErr.Problems, frame.Meta.Custom.(map[string]interface{})["schemaProblems"])
+
+	// Raw queries are not checked, neither are queries whose schema cannot be loaded.
+	assert.NoError(t, introspectWithSchema(t, schema, `{"rawQuery":true,"queryText":"SELECT * FROM x","table":"x"}`))
+	schema.err = errors.New("connection refused")
+	assert.NoError(t, introspectWithSchema(t, schema, `{"table":"cpux","select":[[{"type":"field","params":["usage_idle"]}]]}`))
+}
+
+func TestIsNumericType(t *testing.T) {
+	for _, dataType := range []string{"BIGINT", "BIGINT UNSIGNED", "bigint unsigned", "DOUBLE", "DECIMAL(10,2)"} {
+		assert.True(t, isNumericType(dataType), dataType)
+	}
+	for _, dataType := range []string{"INTERVAL", "POINT", "STRING", "BOOLEAN", "TIMESTAMP(NANOSECOND)", ""} {
+		assert.False(t, isNumericType(dataType), dataType)
+	}
+}
+
+func TestClosestName(t *testing.T) {
+	names := []string{"usage_idle", "usage_user", "host"}
+	assert.Equal(t, "usage_user", closestName("usage_usr", names))
+	assert.Equal(t, "host", closestName("HOST", names))
+	assert.Equal(t, "host", closestName("hots", names))
+	assert.Equal(t, "", closestName("region", names))
+	assert.Equal(t, 1, editDistance("ab", "ba"))
+	assert.Equal(t, 3, editDistance("", "abc"))
+}
@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// SplitSeries splits a long frame, which has a row for each combination of time and tag values,
+// into one frame per combination of tag values. The tag fields are removed and their values
+// become the labels of the value fields. Frames are sorted by their labels.
+func SplitSeries(frame *data.Frame, tagKeys []string) (data.Frames, error) {
+	isTag := make(map[string]bool)
+	for _, key := range tagKeys {
+		isTag[key] = true
+	}
+
+	var tagIndices, valueIndices []int
+	for i, field := range frame.Fields {
+		if isTag[field.Name] {
+			tagIndices = append(tagIndices, i)
+		} else {
+			valueIndices = append(valueIndices, i)
+		}
+	}
+	if len(tagIndices) == 0 {
+		return data.Frames{frame}, nil
+	}
+
+	rowLen, err := frame.RowLen()
+	if err != nil {
+		return nil, err
+	}
+
+	seriesMap := make(map[string]*data.Frame)
+	for row := 0; row < rowLen; row++ {
+		labels := make(data.Labels, len(tagIndices))
+		for _, idx := range tagIndices {
+			field := frame.Fields[idx]
+			// Null tag values are equivalent to "".
+			labels[field.Name] = ""
+			if val, ok := field.ConcreteAt(row); ok {
+				labels[field.Name] = fmt.Sprint(val)
+			}
+		}
+
+		key := labels.String()
+		s, ok := seriesMap[key]
+		if !ok {
+			s = data.NewFrame(frame.Name)
+			for _, idx := range valueIndices {
+				field := frame.Fields[idx]
+				newField := data.NewFieldFromFieldType(field.Type(), 0)
+				newField.Name = field.Name
+				// Each series has its own config, so that setting it on one series leaves the others.
+				if field.Config != nil {
+					cfg := *field.Config
+					newField.Config = &cfg
+				}
+				if field.Type().Time() {
+					newField.Labels = field.Labels
+				} else {
+					newField.Labels = labels.Copy()
+				}
+				s.Fields = append(s.Fields, newField)
+			}
+			seriesMap[key] = s
+		}
+
+		for i, idx := range valueIndices {
+			s.Fields[i].Append(frame.Fields[idx].At(row))
+		}
+	}
+
+	if len(seriesMap) == 0 {
+		return data.Frames{frame}, nil
+	}
+
+	keys := make([]string, 0, len(seriesMap))
+	for key := range seriesMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	frames := make(data.Frames, 0, len(keys))
+	for _, key := range keys {
+		frames = append(frames, seriesMap[key])
+	}
+	frames[0].Meta = frame.Meta
+	return frames, nil
+}
@@ -0,0 +1,59 @@
+package plugin_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnosdb/cnosdb-grafana-datasource-backend/pkg/plugin"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitSeries(t *testing.T) {
+	t0 := time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	str := func(s string) *string { return &s }
+	num := func(f float64) *float64 { return &f }
+
+	frame := data.NewFrame("response",
+		data.NewField("time", nil, []time.Time{t0, t0, t1, t1}),
+		data.NewField("host", nil, []*string{str("b"), str("a"), str("a"), nil}),
+		data.NewField("value", nil, []*float64{num(1), num(2), num(3), num(4)}).SetConfig(&data.FieldConfig{Unit: "percent"}),
+	)
+
+	frames, err := plugin.SplitSeries(frame, []string{"host"})
+	require.NoError(t, err)
+	require.Len(t, frames, 3)
+
+	// Frames are sorted by labels, a null tag value is an empty label.
+	assert.Equal(t, data.Labels{"host": ""}, frames[0].Fields[1].Labels)
+	assert.Equal(t, data.Labels{"host": "a"}, frames[1].Fields[1].Labels)
+	assert.Equal(t, data.Labels{"host": "b"}, frames[2].Fields[1].Labels)
+
+	a := frames[1]
+	require.Len(t, a.Fields, 2)
+	assert.Equal(t, "time", a.Fields[0].Name)
+	assert.Nil(t, a.Fields[0].Labels)
+	assert.Equal(t, 2, a.Rows())
+	assert.Equal(t, t1, a.Fields[0].At(1))
+	assert.Equal(t, 3.0, *a.Fields[1].At(1).(*float64))
+
+	// The config is copied to each series.
+	assert.Equal(t, "percent", a.Fields[1].Config.Unit)
+	a.Fields[1].Config.DisplayNameFromDS = "a"
+	assert.Empty(t, frames[2].Fields[1].Config.DisplayNameFromDS)
+	assert.Empty(t, frame.Fields[2].Config.DisplayNameFromDS)
+}
+
+func TestSplitSeriesWithoutTags(t *testing.T) {
+	frame := data.NewFrame("response",
+		data.NewField("time", nil, []time.Time{time.Now()}),
+		data.NewField("value", nil, []float64{1}),
+	)
+
+	frames, err := plugin.SplitSeries(frame, []string{"host"})
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	assert.Equal(t, frame, frames[0])
+}
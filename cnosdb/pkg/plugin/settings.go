@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	DEFAULT_MAX_CONCURRENT_QUERIES = 4
+	DEFAULT_MAX_RESPONSE_SIZE      = 256 << 20
+	DEFAULT_CACHE_MAX_SIZE         = 64 << 20
+	// DEFAULT_MAX_INCREMENTAL_QUERIES is the number of queries whose previous results are kept to fetch them incrementally.
+	DEFAULT_MAX_INCREMENTAL_QUERIES = 256
+	DEFAULT_INCREMENTAL_MAX_SIZE    = 64 << 20
+	DEFAULT_INCREMENTAL_TTL         = time.Hour
+	// DEFAULT_INCREMENTAL_GRACE_PERIOD is how long rows may arrive late, buckets are not closed before.
+	DEFAULT_INCREMENTAL_GRACE_PERIOD = time.Minute
+	DEFAULT_DISK_CACHE_MAX_SIZE      = 1 << 30
+	DEFAULT_IMMUTABLE_AFTER          = 24 * time.Hour
+
+	// MAX_ERROR_RESPONSE_SIZE is the maximum bytes read from a response with an error status.
+	MAX_ERROR_RESPONSE_SIZE = 64 << 10
+)
+
+// DatasourceSettings holds the options configured in the datasource's JSONData.
+type DatasourceSettings struct {
+	// MaxConcurrentQueries is the maximum number of queries of one request running at the same time.
+	MaxConcurrentQueries int
+	// QueryTimeout is the default time limit of a query, it can be overridden by QueryModel.Timeout.
+	// Queries are only bounded by the deadline of the Grafana request if it is 0.
+	QueryTimeout time.Duration
+	// MaxResponseSize is the maximum bytes of a query response, the rest of the response is dropped.
+	MaxResponseSize int64
+	// Timezone is the default time zone of queries, it can be overridden by QueryModel.Tz.
+	Timezone string
+	// MinInterval is the lower bound of the interval of time($__interval) and time(auto).
+	MinInterval time.Duration
+	// CacheTTL is how long query results are cached, results are not cached if it is 0.
+	CacheTTL time.Duration
+	// CacheMaxSize is the maximum bytes of the cached results, the least recently used are evicted.
+	CacheMaxSize int64
+	// IncrementalQueries enables fetching only the new rows of queries over rolling time windows.
+	IncrementalQueries bool
+	// IncrementalMaxSize is the maximum bytes of the results kept for incremental queries.
+	IncrementalMaxSize int64
+	// IncrementalTTL is how long the result of an incremental query is kept after it was fetched.
+	IncrementalTTL time.Duration
+	// IncrementalGracePeriod is how long after its end a time bucket may still receive rows,
+	// the rows of the bucket are fetched again until then.
+	IncrementalGracePeriod time.Duration
+	// DiskCacheDir is the directory of the disk cache of results older than ImmutableAfter,
+	// relative to the root directory set by DISK_CACHE_ROOT_ENV. The disk cache is disabled if
+	// it is empty.
+	DiskCacheDir string
+	// DiskCacheMaxSize is the maximum bytes of the files of the disk cache.
+	DiskCacheMaxSize int64
+	// ImmutableAfter is the age after which the rows of CnosDB do not change anymore.
+	ImmutableAfter time.Duration
+	// ValidateSchema enables checking the tables and columns of queries against the schema of the database.
+	ValidateSchema bool
+}
+
+// LoadSettings parses the JSONData of a datasource instance. Values may be
+// either JSON strings (as written by the config editor) or JSON numbers
+// (as written by provisioning files).
+func LoadSettings(instanceSettings backend.DataSourceInstanceSettings) (*DatasourceSettings, error) {
+	jsonData := make(map[string]interface{})
+	if len(instanceSettings.JSONData) > 0 {
+		if err := json.Unmarshal(instanceSettings.JSONData, &jsonData); err != nil {
+			return nil, err
+		}
+	}
+
+	settings := &DatasourceSettings{}
+	var err error
+	if settings.MaxConcurrentQueries, err = intSetting(jsonData, "maxConcurrentQueries", DEFAULT_MAX_CONCURRENT_QUERIES); err != nil {
+		return nil, err
+	}
+	if settings.MaxConcurrentQueries <= 0 {
+		return nil, fmt.Errorf("invalid setting 'maxConcurrentQueries': must be greater than 0")
+	}
+	if settings.QueryTimeout, err = durationSetting(jsonData, "queryTimeout", 0); err != nil {
+		return nil, err
+	}
+	maxResponseSize, err := intSetting(jsonData, "maxResponseSize", DEFAULT_MAX_RESPONSE_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	if maxResponseSize <= 0 {
+		return nil, fmt.Errorf("invalid setting 'maxResponseSize': must be greater than 0")
+	}
+	settings.MaxResponseSize = int64(maxResponseSize)
+
+	if settings.Timezone, err = stringSetting(jsonData, "timezone"); err != nil {
+		return nil, err
+	}
+	if _, err = time.LoadLocation(settings.Timezone); err != nil {
+		return nil, fmt.Errorf("invalid setting 'timezone': %s", err)
+	}
+
+	// Grafana writes the minimum interval as "10s" or ">10s", both are lower bounds.
+	if timeInterval, ok := jsonData["timeInterval"].(string); ok {
+		jsonData["timeInterval"] = strings.TrimPrefix(strings.TrimSpace(timeInterval), ">")
+	}
+	if settings.MinInterval, err = durationSetting(jsonData, "timeInterval", 0); err != nil {
+		return nil, err
+	}
+
+	if settings.CacheTTL, err = durationSetting(jsonData, "cacheTTL", 0); err != nil {
+		return nil, err
+	}
+	cacheMaxSize, err := intSetting(jsonData, "cacheMaxSize", DEFAULT_CACHE_MAX_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	if cacheMaxSize <= 0 {
+		return nil, fmt.Errorf("invalid setting 'cacheMaxSize': must be greater than 0")
+	}
+	settings.CacheMaxSize = int64(cacheMaxSize)
+
+	if settings.IncrementalQueries, err = boolSetting(jsonData, "incrementalQueries"); err != nil {
+		return nil, err
+	}
+	incrementalMaxSize, err := intSetting(jsonData, "incrementalMaxSize", DEFAULT_INCREMENTAL_MAX_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	if incrementalMaxSize <= 0 {
+		return nil, fmt.Errorf("invalid setting 'incrementalMaxSize': must be greater than 0")
+	}
+	settings.IncrementalMaxSize = int64(incrementalMaxSize)
+	if settings.IncrementalTTL, err = durationSetting(jsonData, "incrementalTTL", DEFAULT_INCREMENTAL_TTL); err != nil {
+		return nil, err
+	}
+	if settings.IncrementalTTL <= 0 {
+		return nil, fmt.Errorf("invalid setting 'incrementalTTL': must be greater than 0")
+	}
+	if settings.IncrementalGracePeriod, err = durationSetting(jsonData, "incrementalGracePeriod", DEFAULT_INCREMENTAL_GRACE_PERIOD); err != nil {
+		return nil, err
+	}
+	if settings.IncrementalGracePeriod < 0 {
+		return nil, fmt.Errorf("invalid setting 'incrementalGracePeriod': must not be negative")
+	}
+
+	if settings.DiskCacheDir, err = stringSetting(jsonData, "diskCacheDir"); err != nil {
+		return nil, err
+	}
+	if settings.DiskCacheDir != "" {
+		if err = validateDiskCacheDir(settings.DiskCacheDir); err != nil {
+			return nil, fmt.Errorf("invalid setting 'diskCacheDir': %s", err)
+		}
+	}
+	diskCacheMaxSize, err := intSetting(jsonData, "diskCacheMaxSize", DEFAULT_DISK_CACHE_MAX_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	if diskCacheMaxSize <= 0 {
+		return nil, fmt.Errorf("invalid setting 'diskCacheMaxSize': must be greater than 0")
+	}
+	settings.DiskCacheMaxSize = int64(diskCacheMaxSize)
+	if settings.ImmutableAfter, err = durationSetting(jsonData, "immutableAfter", DEFAULT_IMMUTABLE_AFTER); err != nil {
+		return nil, err
+	}
+	if settings.ImmutableAfter <= 0 {
+		return nil, fmt.Errorf("invalid setting 'immutableAfter': must be greater than 0")
+	}
+
+	if settings.ValidateSchema, err = boolSetting(jsonData, "validateSchema"); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+func stringSetting(jsonData map[string]interface{}, key string) (string, error) {
+	val, exists := jsonData[key]
+	if !exists || val == nil {
+		return "", nil
+	}
+	switch v := val.(type) {
+	case string:
+		return strings.TrimSpace(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("invalid setting '%s': unexpected value %v", key, val)
+	}
+}
+
+func intSetting(jsonData map[string]interface{}, key string, defaultValue int) (int, error) {
+	str, err := stringSetting(jsonData, key)
+	if err != nil {
+		return 0, err
+	}
+	if str == "" {
+		return defaultValue, nil
+	}
+	num, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid setting '%s': %s", key, err)
+	}
+	return num, nil
+}
+
+func boolSetting(jsonData map[string]interface{}, key string) (bool, error) {
+	str, err := stringSetting(jsonData, key)
+	if err != nil {
+		return false, err
+	}
+	if str == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(str)
+	if err != nil {
+		return false, fmt.Errorf("invalid setting '%s': %s", key, err)
+	}
+	return b, nil
+}
+
+func durationSetting(jsonData map[string]interface{}, key string, defaultValue time.Duration) (time.Duration, error) {
+	str, err := stringSetting(jsonData, key)
+	if err != nil {
+		return 0, err
+	}
+	if str == "" {
+		return defaultValue, nil
+	}
+	duration, err := ParseDurationString(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid setting '%s': %s", key, err)
+	}
+	return duration, nil
+}
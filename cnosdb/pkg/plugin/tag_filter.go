@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tagOperator describes an operator of tag filters.
+type tagOperator struct {
+	// values is the number of values the operator takes, -1 for a list of values.
+	values int
+	// numeric operators compare with numbers.
+	numeric bool
+	// render renders the filter of the quoted key, the values are already quoted.
+	render func(key string, values []string) string
+}
+
+func binaryOperator(op string) func(key string, values []string) string {
+	return func(key string, values []string) string {
+		return fmt.Sprintf("%s %s %s", key, op, values[0])
+	}
+}
+
+func listOperator(op string) func(key string, values []string) string {
+	return func(key string, values []string) string {
+		return fmt.Sprintf("%s %s (%s)", key, op, strings.Join(values, ", "))
+	}
+}
+
+// tagOperators are the operators allowed in tag filters, by their canonical name.
+var tagOperators = map[string]tagOperator{
+	"=":           {values: 1, render: binaryOperator("=")},
+	"!=":          {values: 1, render: binaryOperator("!=")},
+	"<":           {values: 1, numeric: true, render: binaryOperator("<")},
+	"<=":          {values: 1, numeric: true, render: binaryOperator("<=")},
+	">":           {values: 1, numeric: true, render: binaryOperator(">")},
+	">=":          {values: 1, numeric: true, render: binaryOperator(">=")},
+	"LIKE":        {values: 1, render: binaryOperator("LIKE")},
+	"NOT LIKE":    {values: 1, render: binaryOperator("NOT LIKE")},
+	"IN":          {values: -1, render: listOperator("IN")},
+	"NOT IN":      {values: -1, render: listOperator("NOT IN")},
+	"IS NULL":     {values: 0, render: func(key string, values []string) string { return key + " IS NULL" }},
+	"IS NOT NULL": {values: 0, render: func(key string, values []string) string { return key + " IS NOT NULL" }},
+	// regexp_match returns NULL if the value does not match the pattern.
+	"=~": {values: 1, render: func(key string, values []string) string {
+		return fmt.Sprintf("regexp_match(%s, %s) IS NOT NULL", key, values[0])
+	}},
+	"!~": {values: 1, render: func(key string, values []string) string {
+		return fmt.Sprintf("regexp_match(%s, %s) IS NULL", key, values[0])
+	}},
+}
+
+// tagOperatorAliases are the other names of operators.
+var tagOperatorAliases = map[string]string{
+	"==": "=",
+	"<>": "!=",
+}
+
+// tagOperatorNames returns the sorted names of the allowed operators.
+func tagOperatorNames() string {
+	names := make([]string, 0, len(tagOperators))
+	for name := range tagOperators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// canonicalTagOperator returns the canonical name of an operator, keywords are upper-cased
+// and separated by a single space.
+func canonicalTagOperator(op string) string {
+	op = strings.ToUpper(strings.Join(strings.Fields(op), " "))
+	if alias, ok := tagOperatorAliases[op]; ok {
+		return alias
+	}
+	return op
+}
+
+// splitTagValues splits a multi-valued variable formatted as "{a,b}" or "(a, b)" into its values.
+// A value quoted with ' or " may contain commas, its quote is escaped by doubling it. The frontend
+// splits values with the same rules.
+func splitTagValues(value string) []string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '{' && value[len(value)-1] == '}' || value[0] == '(' && value[len(value)-1] == ')') {
+		value = value[1 : len(value)-1]
+	}
+	var values []string
+	var sb strings.Builder
+	quote, quoted := byte(0), false
+	flush := func() {
+		if v := strings.TrimSpace(sb.String()); v != "" || quoted {
+			if quoted {
+				v = sb.String()
+			}
+			values = append(values, v)
+		}
+		sb.Reset()
+		quoted = false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case quote != 0 && c == quote && i+1 < len(value) && value[i+1] == quote:
+			sb.WriteByte(c)
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			sb.WriteByte(c)
+		case c == ',':
+			flush()
+		case (c == '\'' || c == '"') && !quoted && strings.TrimSpace(sb.String()) == "":
+			sb.Reset()
+			quote, quoted = c, true
+		case quoted && c == ' ':
+			// Spaces after the closing quote.
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	flush()
+	return values
+}
+
+// trimRegex removes the slashes around a regex written as "/pattern/".
+func trimRegex(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// introspect validates the operator and the values of a tag filter.
+func (tag *TagItem) introspect() error {
+	// If the operator is missing we fall back to sensible defaults
+	if tag.Operator == "" {
+		tag.Operator = "="
+		if trimRegex(tag.Value) != tag.Value {
+			tag.Operator = "=~"
+		}
+	}
+	tag.Operator = canonicalTagOperator(tag.Operator)
+	op, ok := tagOperators[tag.Operator]
+	if !ok {
+		return fmt.Errorf("unsupported operator %q in the filter of tag %q, expected one of %s",
+			tag.Operator, tag.Key, tagOperatorNames())
+	}
+
+	// A list of values compares with any of them.
+	if len(tag.Values) > 1 {
+		switch tag.Operator {
+		case "=":
+			tag.Operator, op = "IN", tagOperators["IN"]
+		case "!=":
+			tag.Operator, op = "NOT IN", tagOperators["NOT IN"]
+		}
+	}
+
+	switch {
+	case op.values < 0:
+		if len(tag.Values) == 0 {
+			tag.Values = splitTagValues(tag.Value)
+		}
+		if len(tag.Values) == 0 {
+			return fmt.Errorf("operator %s in the filter of tag %q needs at least one value", tag.Operator, tag.Key)
+		}
+	case op.values == 0:
+		tag.Values = nil
+	default:
+		if len(tag.Values) == 0 {
+			tag.Values = []string{tag.Value}
+		}
+		if len(tag.Values) != 1 {
+			return fmt.Errorf("operator %s in the filter of tag %q takes one value, got %d", tag.Operator, tag.Key, len(tag.Values))
+		}
+	}
+
+	if op.numeric {
+		if _, err := NumberLiteral(tag.Values[0]); err != nil {
+			return fmt.Errorf("operator %s in the filter of tag %q compares with numbers: %s", tag.Operator, tag.Key, err)
+		}
+	}
+	return nil
+}
+
+// render renders the filter, introspect must have been called.
+func (tag *TagItem) render() string {
+	op := tagOperators[tag.Operator]
+	values := make([]string, len(tag.Values))
+	for i, value := range tag.Values {
+		switch {
+		case op.numeric:
+			values[i], _ = NumberLiteral(value)
+		case tag.Operator == "=~" || tag.Operator == "!~":
+			values[i] = QuoteString(trimRegex(value))
+		default:
+			values[i] = QuoteString(value)
+		}
+	}
+	return op.render(QuoteIdentifier(tag.Key), values)
+}
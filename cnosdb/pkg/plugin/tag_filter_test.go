@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagFilter(t *testing.T) {
+	tests := []struct {
+		tag      TagItem
+		expected string
+	}{
+		{TagItem{Key: "host", Value: "a"}, `"host" = 'a'`},
+		{TagItem{Key: "host", Operator: "<>", Value: "a"}, `"host" != 'a'`},
+		{TagItem{Key: "host", Value: "/^web-\\d+$/"}, `regexp_match("host", '^web-\d+$') IS NOT NULL`},
+		{TagItem{Key: "host", Operator: "!~", Value: "/it's/"}, `regexp_match("host", 'it''s') IS NULL`},
+		{TagItem{Key: "host", Operator: "in", Value: "{a,b, c}"}, `"host" IN ('a', 'b', 'c')`},
+		{TagItem{Key: "host", Operator: "IN", Value: `('a,b', "it's", 'x''y' , c)`}, `"host" IN ('a,b', 'it''s', 'x''y', 'c')`},
+		{TagItem{Key: "host", Operator: "not  in", Values: []string{"a", "b"}}, `"host" NOT IN ('a', 'b')`},
+		{TagItem{Key: "host", Operator: "=", Values: []string{"a", "b"}}, `"host" IN ('a', 'b')`},
+		{TagItem{Key: "host", Operator: "!=", Values: []string{"a", "b"}}, `"host" NOT IN ('a', 'b')`},
+		{TagItem{Key: "host", Operator: "like", Value: "web-%"}, `"host" LIKE 'web-%'`},
+		{TagItem{Key: "host", Operator: "NOT LIKE", Value: "web-%"}, `"host" NOT LIKE 'web-%'`},
+		{TagItem{Key: "host", Operator: "is null"}, `"host" IS NULL`},
+		{TagItem{Key: "host", Operator: "IS NOT NULL", Value: "ignored"}, `"host" IS NOT NULL`},
+		{TagItem{Key: "usage", Operator: ">", Value: "0.5"}, `"usage" > 0.5`},
+		{TagItem{Key: "usage", Operator: "<=", Value: " 10 "}, `"usage" <= 10`},
+	}
+	for _, test := range tests {
+		tag := test.tag
+		require.NoError(t, tag.introspect(), test.expected)
+		assert.Equal(t, test.expected, tag.render())
+	}
+}
+
+func TestTagFilterInvalid(t *testing.T) {
+	for _, tag := range []TagItem{
+		{Key: "host", Operator: "===", Value: "a"},
+		{Key: "host", Operator: "BETWEEN", Value: "a"},
+		{Key: "host", Operator: "IN", Value: "{}"},
+		{Key: "host", Operator: "LIKE", Values: []string{"a", "b"}},
+		{Key: "usage", Operator: ">", Value: "high"},
+		{Key: "usage", Operator: "<", Value: "1 OR 1=1"},
+	} {
+		assert.Error(t, tag.introspect(), tag.Operator)
+	}
+
+	tag := TagItem{Key: "host", Operator: "~", Value: "a"}
+	err := tag.introspect()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unsupported operator "~" in the filter of tag "host"`)
+}
@@ -0,0 +1,197 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Transform is a part of a select chain which is computed on the result frames instead of in SQL.
+type Transform struct {
+	// Column is the name of the result column of the select chain.
+	Column string
+	Type   string
+	Params []string
+}
+
+// frameTransform computes the values of a transform from the time and value fields of a frame.
+type frameTransform func(timeField *data.Field, valueField *data.Field, params []string) (*data.Field, error)
+
+// Reset options of difference.
+const (
+	// RESET_COUNTER treats a decrease as a counter reset, the difference is the new value.
+	RESET_COUNTER = "counter"
+	// RESET_NULL leaves negative differences null.
+	RESET_NULL = "null"
+)
+
+var frameTransforms = map[string]frameTransform{
+	"derivative":              derivativeTransform(false),
+	"non_negative_derivative": derivativeTransform(true),
+	"difference":              differenceTransform,
+	"moving_average":          movingAverageTransform,
+}
+
+// newTransformField returns a field of null values to hold the transformed values of valueField.
+func newTransformField(valueField *data.Field) *data.Field {
+	res := data.NewFieldFromFieldType(data.FieldTypeNullableFloat64, valueField.Len())
+	res.Name = valueField.Name
+	res.Labels = valueField.Labels
+	res.Config = valueField.Config
+	return res
+}
+
+// timeOrderedRows returns the rows with a time and a non-null value in time order, along with
+// their times. The query may be ordered by time descending.
+func timeOrderedRows(timeField *data.Field, valueField *data.Field) ([]int, map[int]time.Time) {
+	var rows []int
+	times := make(map[int]time.Time)
+	for i := 0; i < valueField.Len(); i++ {
+		t, ok := timeField.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		if _, ok = valueField.ConcreteAt(i); !ok {
+			continue
+		}
+		rows = append(rows, i)
+		times[i] = t.(time.Time)
+	}
+	sort.SliceStable(rows, func(a, b int) bool {
+		return times[rows[a]].Before(times[rows[b]])
+	})
+	return rows, times
+}
+
+// derivativeTransform computes the rate of change per unit, the first parameter, between
+// consecutive non-null values, rows with a null value stay null. If nonNegative is set,
+// negative rates, which are caused by counter resets, are null.
+func derivativeTransform(nonNegative bool) frameTransform {
+	return func(timeField *data.Field, valueField *data.Field, params []string) (*data.Field, error) {
+		unit := time.Second
+		if len(params) > 0 && params[0] != "" {
+			var err error
+			if unit, err = ParseInterval(params[0]); err != nil {
+				return nil, err
+			}
+		}
+
+		res := newTransformField(valueField)
+		rows, times := timeOrderedRows(timeField, valueField)
+		var prevTime time.Time
+		var prevValue float64
+		for n, i := range rows {
+			value, err := valueField.FloatAt(i)
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 && times[i].After(prevTime) {
+				rate := (value - prevValue) / (float64(times[i].Sub(prevTime)) / float64(unit))
+				if !nonNegative || rate >= 0 {
+					res.Set(i, &rate)
+				}
+			}
+			prevTime, prevValue = times[i], value
+		}
+		return res, nil
+	}
+}
+
+// differenceTransform computes the difference between consecutive non-null values, rows with a
+// null value stay null. The first parameter sets how decreases are handled, see RESET_COUNTER
+// and RESET_NULL, they are kept as negative differences by default.
+func differenceTransform(timeField *data.Field, valueField *data.Field, params []string) (*data.Field, error) {
+	reset := ""
+	if len(params) > 0 {
+		reset = params[0]
+	}
+
+	res := newTransformField(valueField)
+	rows, _ := timeOrderedRows(timeField, valueField)
+	var prevValue float64
+	for n, i := range rows {
+		value, err := valueField.FloatAt(i)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			diff := value - prevValue
+			switch {
+			case diff >= 0:
+				res.Set(i, &diff)
+			case reset == RESET_COUNTER:
+				res.Set(i, &value)
+			case reset != RESET_NULL:
+				res.Set(i, &diff)
+			}
+		}
+		prevValue = value
+	}
+	return res, nil
+}
+
+// movingAverageTransform computes the average of the last n, the first parameter, non-null
+// values. Rows with a null value and the first n-1 values stay null.
+func movingAverageTransform(timeField *data.Field, valueField *data.Field, params []string) (*data.Field, error) {
+	n, err := strconv.Atoi(params[0])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid number of values %q", params[0])
+	}
+
+	res := newTransformField(valueField)
+	rows, _ := timeOrderedRows(timeField, valueField)
+	values := make([]float64, 0, len(rows))
+	for _, i := range rows {
+		value, err := valueField.FloatAt(i)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if len(values) < n {
+			continue
+		}
+		var sum float64
+		for _, v := range values[len(values)-n:] {
+			sum += v
+		}
+		avg := sum / float64(n)
+		res.Set(i, &avg)
+	}
+	return res, nil
+}
+
+// applyTransforms replaces the result columns of transforms in frames by their transformed values.
+func (query *QueryModel) applyTransforms(frames data.Frames) error {
+	for _, transform := range query.Transforms {
+		apply, ok := frameTransforms[transform.Type]
+		if !ok {
+			return fmt.Errorf("missing frame transform for %q", transform.Type)
+		}
+		for _, frame := range frames {
+			var timeField *data.Field
+			for _, field := range frame.Fields {
+				if field.Type().Time() {
+					timeField = field
+					break
+				}
+			}
+			if timeField == nil {
+				continue
+			}
+			for i, field := range frame.Fields {
+				if field.Name != transform.Column || field == timeField {
+					continue
+				}
+				res, err := apply(timeField, field, transform.Params)
+				if err != nil {
+					return fmt.Errorf("failed to compute %s of %q: %s", transform.Type, transform.Column, err)
+				}
+				frame.Fields[i] = res
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func newTransformFrame(values ...*float64) *data.Frame {
+	times := make([]time.Time, len(values))
+	for i := range values {
+		times[i] = time.Unix(int64(i)*10, 0)
+	}
+	return data.NewFrame("",
+		data.NewField("time", nil, times),
+		data.NewField("derivative", data.Labels{"host": "a"}, values),
+	)
+}
+
+func fieldValues(field *data.Field) []interface{} {
+	values := make([]interface{}, field.Len())
+	for i := range values {
+		if v, ok := field.ConcreteAt(i); ok {
+			values[i] = v
+		}
+	}
+	return values
+}
+
+func TestDerivativeTransform(t *testing.T) {
+	query := &QueryModel{Transforms: []*Transform{{Column: "derivative", Type: "derivative"}}}
+	frame := newTransformFrame(float64Ptr(10), float64Ptr(30), nil, float64Ptr(70), float64Ptr(40))
+	require.NoError(t, query.applyTransforms(data.Frames{frame}))
+
+	field := frame.Fields[1]
+	assert.Equal(t, "derivative", field.Name)
+	assert.Equal(t, data.Labels{"host": "a"}, field.Labels)
+	// Nulls are skipped, the rate after them is computed from the last non-null value.
+	assert.Equal(t, []interface{}{nil, 2.0, nil, 2.0, -3.0}, fieldValues(field))
+}
+
+func TestDerivativeTransformUnit(t *testing.T) {
+	query := &QueryModel{Transforms: []*Transform{{Column: "derivative", Type: "derivative", Params: []string{"1m"}}}}
+	frame := newTransformFrame(float64Ptr(10), float64Ptr(30))
+	require.NoError(t, query.applyTransforms(data.Frames{frame}))
+	assert.Equal(t, []interface{}{nil, 120.0}, fieldValues(frame.Fields[1]))
+}
+
+func TestNonNegativeDerivativeTransform(t *testing.T) {
+	query := &QueryModel{Transforms: []*Transform{{Column: "derivative", Type: "non_negative_derivative"}}}
+	// The counter is reset after 50.
+	frame := newTransformFrame(float64Ptr(10), float64Ptr(50), float64Ptr(5), float64Ptr(25))
+	require.NoError(t, query.applyTransforms(data.Frames{frame}))
+	assert.Equal(t, []interface{}{nil, 4.0, nil, 2.0}, fieldValues(frame.Fields[1]))
+}
+
+func TestDerivativeTransformDescending(t *testing.T) {
+	query := &QueryModel{Transforms: []*Transform{{Column: "value", Type: "derivative"}}}
+	frame := data.NewFrame("",
+		data.NewField("time", nil, []time.Time{time.Unix(20, 0), time.Unix(10, 0), time.Unix(0, 0)}),
+		data.NewField("value", nil, []int64{40, 20, 10}),
+	)
+	require.NoError(t, query.applyTransforms(data.Frames{frame}))
+	assert.Equal(t, []interface{}{2.0, 1.0, nil}, fieldValues(frame.Fields[1]))
+}
+
+func TestDifferenceTransform(t *testing.T) {
+	frame := newTransformFrame(float64Ptr(10), nil, float64Ptr(30), float64Ptr(5), float64Ptr(25))
+	query := &QueryModel{Transforms: []*Transform{{Column: "derivative", Type: "difference"}}}
+	require.NoError(t, query.applyTransforms(data.Frames{frame}))
+	// A null row does not null the difference after it.
+	assert.Equal(t, []interface{}{nil, nil, 20.0, -25.0, 20.0}, fieldValues(frame.Fields[1]))
+
+	frame = newTransformFrame(float64Ptr(10), nil, float64Ptr(30), float64Ptr(5), float64Ptr(25))
+	query = &QueryModel{Transforms: []*Transform{{Column: "derivative", Type: "difference", Params: []string{RESET_COUNTER}}}}
+	require.NoError(t, query.applyTransforms(data.Frames{frame}))
+	assert.Equal(t, []interface{}{nil, nil, 20.0, 5.0, 20.0}, fieldValues(frame.Fields[1]))
+
+	frame = newTransformFrame(float64Ptr(10), nil, float64Ptr(30), float64Ptr(5), float64Ptr(25))
+	query = &QueryModel{Transforms: []*Transform{{Column: "derivative", Type: "difference", Params: []string{RESET_NULL}}}}
+	require.NoError(t, query.applyTransforms(data.Frames{frame}))
+	assert.Equal(t, []interface{}{nil, nil, 20.0, nil, 20.0}, fieldValues(frame.Fields[1]))
+}
+
+func TestMovingAverageTransform(t *testing.T) {
+	query := &QueryModel{Transforms: []*Transform{{Column: "derivative", Type: "moving_average", Params: []string{"2"}}}}
+	frame := newTransformFrame(float64Ptr(10), float64Ptr(30), nil, float64Ptr(70), float64Ptr(40))
+	require.NoError(t, query.applyTransforms(data.Frames{frame}))
+	assert.Equal(t, []interface{}{nil, 20.0, nil, 50.0, 55.0}, fieldValues(frame.Fields[1]))
+}
@@ -14,13 +14,22 @@ const (
 )
 
 func ParseTimeString(timeStr string) (time.Time, error) {
+	return ParseTimeStringInLocation(timeStr, time.UTC)
+}
+
+// ParseTimeStringInLocation parses a timestamp returned by CnosDB, timestamps without
+// a time zone offset are parsed as times in loc.
+func ParseTimeStringInLocation(timeStr string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, timeStr); err == nil {
+		return t, nil
+	}
 	switch len(timeStr) {
 	case len(LAYOUT_SECOND):
-		return time.Parse(LAYOUT_SECOND, timeStr)
+		return time.ParseInLocation(LAYOUT_SECOND, timeStr, loc)
 	case len(LAYOUT_MILLISECOND):
-		return time.Parse(LAYOUT_MILLISECOND, timeStr)
+		return time.ParseInLocation(LAYOUT_MILLISECOND, timeStr, loc)
 	default:
-		return time.Parse(LAYOUT_NANOSECOND, timeStr)
+		return time.ParseInLocation(LAYOUT_NANOSECOND, timeStr, loc)
 	}
 }
 
@@ -36,17 +45,171 @@ func ParseIntervalString(intervalStr string) time.Duration {
 	}
 
 	unit := strings.ToLower(seg[1])
-	if strings.HasPrefix(unit, "second") {
+	if strings.HasPrefix(unit, "nanosecond") {
+		return time.Duration(num)
+	} else if strings.HasPrefix(unit, "microsecond") {
+		return time.Duration(num) * time.Microsecond
+	} else if strings.HasPrefix(unit, "millisecond") {
+		return time.Duration(num) * time.Millisecond
+	} else if strings.HasPrefix(unit, "second") {
 		return time.Duration(num) * time.Second
 	} else if strings.HasPrefix(unit, "minute") {
 		return time.Duration(num) * time.Minute
 	} else if strings.HasPrefix(unit, "hour") {
 		return time.Duration(num) * time.Hour
+	} else if strings.HasPrefix(unit, "day") {
+		return time.Duration(num) * 24 * time.Hour
 	} else {
 		return 0
 	}
 }
 
+// ParseInterval parses an interval string such as "10 minutes" or a duration such as "10m".
+// Durations may also be a number of days such as "1d", which time.ParseDuration does not support.
+func ParseInterval(intervalStr string) (time.Duration, error) {
+	if interval := ParseIntervalString(intervalStr); interval > 0 {
+		return interval, nil
+	}
+	if strings.HasSuffix(intervalStr, "d") {
+		if days, err := strconv.ParseInt(strings.TrimSuffix(intervalStr, "d"), 10, 64); err == nil && days > 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+	interval, err := ParseDurationString(intervalStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %q", intervalStr)
+	}
+	return interval, nil
+}
+
+// parseOffset parses the offset of time(), an interval such as "15 minutes" or "15m" which may
+// be negative or 0.
+func parseOffset(offsetStr string) (time.Duration, error) {
+	offsetStr = strings.TrimSpace(offsetStr)
+	sign := time.Duration(1)
+	if strings.HasPrefix(offsetStr, "-") {
+		sign, offsetStr = -1, strings.TrimSpace(offsetStr[1:])
+	}
+	if isZeroInterval(offsetStr) {
+		return 0, nil
+	}
+	offset, err := ParseInterval(offsetStr)
+	if err != nil {
+		return 0, err
+	}
+	return sign * offset, nil
+}
+
+// isZeroInterval tells if intervalStr is a valid interval of 0, such as "0s" or "0 minutes".
+func isZeroInterval(intervalStr string) bool {
+	if intervalStr == "0" {
+		return true
+	}
+	if d, err := time.ParseDuration(intervalStr); err == nil {
+		return d == 0
+	}
+	seg := strings.Fields(intervalStr)
+	if len(seg) != 2 {
+		return false
+	}
+	num, err := strconv.ParseInt(seg[0], 10, 64)
+	return err == nil && num == 0 && ParseIntervalString("1 "+seg[1]) > 0
+}
+
+// roundIntervals maps the upper bound of a range of intervals to the interval they are rounded to.
+var roundIntervals = []struct {
+	upper    time.Duration
+	interval time.Duration
+}{
+	{10 * time.Millisecond, time.Millisecond},
+	{15 * time.Millisecond, 10 * time.Millisecond},
+	{35 * time.Millisecond, 20 * time.Millisecond},
+	{75 * time.Millisecond, 50 * time.Millisecond},
+	{150 * time.Millisecond, 100 * time.Millisecond},
+	{350 * time.Millisecond, 200 * time.Millisecond},
+	{750 * time.Millisecond, 500 * time.Millisecond},
+	{1500 * time.Millisecond, time.Second},
+	{3500 * time.Millisecond, 2 * time.Second},
+	{7500 * time.Millisecond, 5 * time.Second},
+	{12500 * time.Millisecond, 10 * time.Second},
+	{17500 * time.Millisecond, 15 * time.Second},
+	{25 * time.Second, 20 * time.Second},
+	{45 * time.Second, 30 * time.Second},
+	{90 * time.Second, time.Minute},
+	{210 * time.Second, 2 * time.Minute},
+	{450 * time.Second, 5 * time.Minute},
+	{750 * time.Second, 10 * time.Minute},
+	{1050 * time.Second, 15 * time.Minute},
+	{25 * time.Minute, 20 * time.Minute},
+	{45 * time.Minute, 30 * time.Minute},
+	{90 * time.Minute, time.Hour},
+	{150 * time.Minute, 2 * time.Hour},
+	{270 * time.Minute, 3 * time.Hour},
+	{9 * time.Hour, 6 * time.Hour},
+	{18 * time.Hour, 12 * time.Hour},
+	{36 * time.Hour, 24 * time.Hour},
+	{84 * time.Hour, 48 * time.Hour},
+}
+
+// RoundInterval rounds an interval to a nearby "nice" interval like Grafana does,
+// intervals longer than a week are rounded to a multiple of weeks.
+func RoundInterval(interval time.Duration) time.Duration {
+	for _, r := range roundIntervals {
+		if interval <= r.upper {
+			return r.interval
+		}
+	}
+	week := 7 * 24 * time.Hour
+	return (interval + week/2) / week * week
+}
+
+// FormatIntervalString formats a duration as an interval string of CnosDB such as "10 minutes",
+// using the largest unit the duration is a multiple of.
+func FormatIntervalString(interval time.Duration) string {
+	units := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+		{"second", time.Second},
+		{"millisecond", time.Millisecond},
+		{"microsecond", time.Microsecond},
+		{"nanosecond", time.Nanosecond},
+	}
+	for _, unit := range units {
+		if interval%unit.duration != 0 {
+			continue
+		}
+		num := int64(interval / unit.duration)
+		if num == 1 {
+			return fmt.Sprintf("1 %s", unit.name)
+		}
+		return fmt.Sprintf("%d %ss", num, unit.name)
+	}
+	return fmt.Sprintf("%d nanoseconds", int64(interval))
+}
+
+// ParseDurationString parses a Go duration string such as "30s" or "1m30s".
+// A plain number is treated as a number of seconds.
+func ParseDurationString(durationStr string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(durationStr, 64); err == nil {
+		if seconds <= 0 {
+			return 0, fmt.Errorf("duration must be greater than 0: %q", durationStr)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	duration, err := time.ParseDuration(durationStr)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration must be greater than 0: %q", durationStr)
+	}
+	return duration, nil
+}
+
 func typeof(value interface{}) string {
 	if value != nil {
 		return fmt.Sprintf("%T", value)
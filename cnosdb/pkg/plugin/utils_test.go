@@ -16,4 +16,81 @@ func TestParseIntervalString(t *testing.T) {
 
 	interval = ParseIntervalString("10 hours")
 	assert.Equal(t, interval, time.Duration(10)*time.Hour)
+
+	interval = ParseIntervalString("500 milliseconds")
+	assert.Equal(t, interval, time.Duration(500)*time.Millisecond)
+
+	interval = ParseIntervalString("1 day")
+	assert.Equal(t, interval, time.Duration(24)*time.Hour)
+}
+
+func TestFormatIntervalString(t *testing.T) {
+	assert.Equal(t, "1 minute", FormatIntervalString(time.Minute))
+	assert.Equal(t, "90 seconds", FormatIntervalString(90*time.Second))
+	assert.Equal(t, "2 days", FormatIntervalString(48*time.Hour))
+	assert.Equal(t, "1500 milliseconds", FormatIntervalString(1500*time.Millisecond))
+}
+
+func TestParseInterval(t *testing.T) {
+	interval, err := ParseInterval("10 minutes")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, interval)
+
+	interval, err = ParseInterval("10m")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, interval)
+
+	interval, err = ParseInterval("7d")
+	assert.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, interval)
+
+	_, err = ParseInterval("10 fortnights")
+	assert.Error(t, err)
+	_, err = ParseInterval("0d")
+	assert.Error(t, err)
+}
+
+func TestRoundInterval(t *testing.T) {
+	assert.Equal(t, time.Millisecond, RoundInterval(3*time.Millisecond))
+	assert.Equal(t, time.Second, RoundInterval(1200*time.Millisecond))
+	assert.Equal(t, 20*time.Second, RoundInterval(21600*time.Millisecond))
+	assert.Equal(t, 5*time.Minute, RoundInterval(4*time.Minute))
+	assert.Equal(t, time.Hour, RoundInterval(75*time.Minute))
+	assert.Equal(t, 24*time.Hour, RoundInterval(30*time.Hour))
+	assert.Equal(t, 7*24*time.Hour, RoundInterval(5*24*time.Hour))
+	assert.Equal(t, 14*24*time.Hour, RoundInterval(12*24*time.Hour))
+}
+
+func TestParseDurationString(t *testing.T) {
+	duration, err := ParseDurationString("90s")
+	assert.NoError(t, err)
+	assert.Equal(t, duration, 90*time.Second)
+
+	duration, err = ParseDurationString("30")
+	assert.NoError(t, err)
+	assert.Equal(t, duration, 30*time.Second)
+
+	_, err = ParseDurationString("-1m")
+	assert.Error(t, err)
+
+	_, err = ParseDurationString("ten seconds")
+	assert.Error(t, err)
+}
+
+func TestParseTimeStringInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	assert.NoError(t, err)
+
+	parsed, err := ParseTimeStringInLocation("2022-10-10 08:00:00", loc)
+	assert.NoError(t, err)
+	assert.True(t, parsed.Equal(time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC)))
+
+	parsed, err = ParseTimeStringInLocation("2022-10-10 08:00:00.500", loc)
+	assert.NoError(t, err)
+	assert.True(t, parsed.Equal(time.Date(2022, 10, 10, 0, 0, 0, 500000000, time.UTC)))
+
+	// Timestamps with an offset are not affected by the location.
+	parsed, err = ParseTimeStringInLocation("2022-10-10T08:00:00Z", loc)
+	assert.NoError(t, err)
+	assert.True(t, parsed.Equal(time.Date(2022, 10, 10, 8, 0, 0, 0, time.UTC)))
 }
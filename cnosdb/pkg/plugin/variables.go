@@ -0,0 +1,257 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ALL_VALUE is the value of a variable set to All, without a custom all value.
+const ALL_VALUE = "$__all"
+
+// ScopedVar is the value of a template variable, sent in the query JSON.
+type ScopedVar struct {
+	Text  interface{} `json:"text,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// values returns the values of the variable, a multi-value variable has several values.
+func (v ScopedVar) values() []string {
+	switch value := v.Value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	case nil:
+		return nil
+	default:
+		return []string{fmt.Sprint(value)}
+	}
+}
+
+// variablePattern matches "$name", "${name}", "${name:format}", "[[name]]" and "[[name:format]]".
+var variablePattern = regexp.MustCompile(`\$(\w+)|\$\{(\w+)(?::(\w+))?\}|\[\[(\w+)(?::(\w+))?\]\]`)
+
+// Formats of variable values, the modifier of "${name:format}".
+const (
+	formatCsv       = "csv"
+	formatRegex     = "regex"
+	formatSqlString = "sqlstring"
+	// formatSingle is used where only one value is allowed, such as table and field names.
+	formatSingle = "single"
+	// formatRaw is the default format in SQL text: a single value is inserted as is, so that
+	// '$host' is a string literal, and several values are formatted as sqlstring.
+	formatRaw = "raw"
+)
+
+// variableRef is a reference to a variable found in a text.
+type variableRef struct {
+	name   string
+	format string
+}
+
+func parseVariableRef(match []string) variableRef {
+	switch {
+	case match[1] != "":
+		return variableRef{name: match[1]}
+	case match[2] != "":
+		return variableRef{name: match[2], format: match[3]}
+	default:
+		return variableRef{name: match[4], format: match[5]}
+	}
+}
+
+// lookupVariable returns the variable referenced by ref, variables of Grafana such
+// as $__interval are left to the macros.
+func (query *QueryModel) lookupVariable(ref variableRef) (ScopedVar, bool) {
+	if strings.HasPrefix(ref.name, "__") {
+		return ScopedVar{}, false
+	}
+	v, ok := query.ScopedVars[ref.name]
+	return v, ok
+}
+
+// formatVariable formats the values of a variable:
+//   - csv: values separated by commas.
+//   - regex: values escaped for regular expressions, several values are combined as (a|b).
+//   - sqlstring: values quoted as string literals separated by commas.
+//   - single: the value, several values are not allowed.
+//   - raw: the value with quotes escaped, several values are formatted as sqlstring.
+func formatVariable(name string, values []string, format string) (string, error) {
+	if len(values) == 1 && values[0] == ALL_VALUE {
+		if format == formatRegex {
+			return ".*", nil
+		}
+		return "", fmt.Errorf("variable %q is set to All, which cannot be expanded without its values", name)
+	}
+	switch format {
+	case formatCsv:
+		return strings.Join(values, ","), nil
+	case formatRegex:
+		escaped := make([]string, len(values))
+		for i, value := range values {
+			escaped[i] = regexp.QuoteMeta(value)
+		}
+		if len(escaped) == 1 {
+			return escaped[0], nil
+		}
+		return "(" + strings.Join(escaped, "|") + ")", nil
+	case formatSqlString:
+		quoted := make([]string, len(values))
+		for i, value := range values {
+			quoted[i] = QuoteString(value)
+		}
+		return strings.Join(quoted, ","), nil
+	case formatRaw:
+		if len(values) == 1 {
+			return escapeQuotes(values[0]), nil
+		}
+		return formatVariable(name, values, formatSqlString)
+	case formatSingle:
+		if len(values) != 1 {
+			return "", fmt.Errorf("variable %q must have one value, got %d", name, len(values))
+		}
+		return values[0], nil
+	}
+	return "", fmt.Errorf("unsupported format %q of variable %q, expected csv, regex or sqlstring", format, name)
+}
+
+// interpolateVariables replaces the variables in text, formatted with their format modifier
+// or defaultFormat. Unknown variables are kept as they are. If defaultFormat is formatRaw, the
+// text is SQL and quotes are escaped in every format, since the value may be in a string literal.
+func (query *QueryModel) interpolateVariables(text string, defaultFormat string) (string, error) {
+	if len(query.ScopedVars) == 0 {
+		return text, nil
+	}
+	var err error
+	res := variablePattern.ReplaceAllStringFunc(text, func(in string) string {
+		ref := parseVariableRef(variablePattern.FindStringSubmatch(in))
+		v, ok := query.lookupVariable(ref)
+		if !ok || err != nil {
+			return in
+		}
+		format := ref.format
+		if format == "" {
+			format = defaultFormat
+		}
+		var formatted string
+		formatted, err = formatVariable(ref.name, v.values(), format)
+		if defaultFormat == formatRaw && format != formatRaw && format != formatSqlString {
+			formatted = escapeQuotes(formatted)
+		}
+		return formatted
+	})
+	return res, err
+}
+
+// interpolateTagVariables expands the variables of a tag filter. A value which is only a
+// variable is compared with each of its values, so a multi-value variable becomes IN (...).
+// It returns false if the filter matches anything, which is a positive filter set to All.
+func (query *QueryModel) interpolateTagVariables(tag *TagItem) (bool, error) {
+	var err error
+	if tag.Key, err = query.interpolateVariables(tag.Key, formatSingle); err != nil {
+		return false, err
+	}
+
+	op := canonicalTagOperator(tag.Operator)
+	if op == "=~" || op == "!~" || op == "" && trimRegex(tag.Value) != tag.Value {
+		tag.Value, err = query.interpolateVariables(tag.Value, formatRegex)
+		return true, err
+	}
+
+	if match := variablePattern.FindStringSubmatch(tag.Value); match != nil && match[0] == tag.Value {
+		ref := parseVariableRef(match)
+		if v, ok := query.lookupVariable(ref); ok && ref.format == "" {
+			values := v.values()
+			if len(values) == 1 && values[0] == ALL_VALUE {
+				switch op {
+				case "", "=", "==", "IN":
+					return false, nil
+				}
+				return false, fmt.Errorf("variable %q is set to All, which cannot be expanded without its values", ref.name)
+			}
+			tag.Value = ""
+			tag.Values = values
+			return true, nil
+		}
+	}
+
+	tag.Value, err = query.interpolateVariables(tag.Value, formatCsv)
+	return true, err
+}
+
+// interpolateTagsVariables expands the variables of tag filters. Filters set to All are dropped
+// if the filters are combined with AND, otherwise they match any value of their tag.
+func (query *QueryModel) interpolateTagsVariables(tags []*TagItem) ([]*TagItem, error) {
+	anyOr := false
+	for i, tag := range tags {
+		if i > 0 && strings.EqualFold(tag.Condition, "OR") {
+			anyOr = true
+		}
+	}
+
+	var res []*TagItem
+	for _, tag := range tags {
+		keep, err := query.interpolateTagVariables(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !keep {
+			if !anyOr {
+				continue
+			}
+			tag.Operator, tag.Value, tag.Values = "=~", ".*", nil
+		}
+		res = append(res, tag)
+	}
+	return res, nil
+}
+
+// applyVariables expands the template variables of the query with ScopedVars.
+func (query *QueryModel) applyVariables() error {
+	if len(query.ScopedVars) == 0 {
+		return nil
+	}
+
+	var err error
+	if query.QueryText, err = query.interpolateVariables(query.QueryText, formatRaw); err != nil {
+		return err
+	}
+	if query.RawTagsExpr, err = query.interpolateVariables(query.RawTagsExpr, formatRaw); err != nil {
+		return err
+	}
+	if query.Table, err = query.interpolateVariables(query.Table, formatSingle); err != nil {
+		return err
+	}
+	// A new slice, appending to GroupBy could overwrite the items after it in its array.
+	size := len(query.GroupBy)
+	for _, sel := range query.Select {
+		size += len(sel)
+	}
+	parts := make([]*SelectItem, 0, size)
+	parts = append(parts, query.GroupBy...)
+	for _, sel := range query.Select {
+		parts = append(parts, sel...)
+	}
+	for _, part := range parts {
+		for i, param := range part.Params {
+			if part.Params[i], err = query.interpolateVariables(param, formatSingle); err != nil {
+				return err
+			}
+		}
+	}
+	if query.Tags, err = query.interpolateTagsVariables(query.Tags); err != nil {
+		return err
+	}
+	if query.AdhocFilters, err = query.interpolateTagsVariables(query.AdhocFilters); err != nil {
+		return err
+	}
+	return nil
+}
+
+// escapeQuotes doubles the single quotes of value, so that it can be put in a string literal.
+func escapeQuotes(value string) string {
+	return strings.ReplaceAll(value, `'`, `''`)
+}
@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildWithVariables(t *testing.T, requestJson string) (string, error) {
+	queryContext := &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			JSON: []byte(requestJson),
+			TimeRange: backend.TimeRange{
+				From: time.Date(2022, 10, 10, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2022, 10, 17, 0, 0, 0, 0, time.UTC),
+			},
+		}},
+	}
+	var query QueryModel
+	require.NoError(t, json.Unmarshal([]byte(requestJson), &query))
+	if err := query.Introspect(); err != nil {
+		return "", err
+	}
+	return query.Build(queryContext)
+}
+
+const timeFilter = `time >= 1665360000000000000 AND time <= 1665964800000000000`
+
+func TestFormatVariable(t *testing.T) {
+	values := []string{"a.b", "it's"}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{formatCsv, `a.b,it's`},
+		{formatRegex, `(a\.b|it's)`},
+		{formatSqlString, `'a.b','it''s'`},
+	}
+	for _, tt := range tests {
+		got, err := formatVariable("v", values, tt.format)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.format)
+	}
+
+	got, err := formatVariable("v", []string{"a.b"}, formatRegex)
+	require.NoError(t, err)
+	assert.Equal(t, `a\.b`, got)
+
+	got, err = formatVariable("v", []string{ALL_VALUE}, formatRegex)
+	require.NoError(t, err)
+	assert.Equal(t, `.*`, got)
+
+	_, err = formatVariable("v", values, formatSingle)
+	assert.EqualError(t, err, `variable "v" must have one value, got 2`)
+	_, err = formatVariable("v", values, "json")
+	assert.Error(t, err)
+	_, err = formatVariable("v", []string{ALL_VALUE}, formatCsv)
+	assert.Error(t, err)
+}
+
+func TestVariablesVisualQuery(t *testing.T) {
+	sql, err := buildWithVariables(t, `
+{
+    "table": "$table",
+    "select": [ [ { "type": "field", "params": [ "$field" ] } ] ],
+    "tags": [
+        { "key": "host", "value": "$host" },
+        { "key": "region", "operator": "!=", "value": "${region}" },
+        { "key": "dc", "value": "/^$dc$/" },
+        { "key": "rack", "value": "$rack" }
+    ],
+    "adhocFilters": [
+        { "key": "app", "operator": "=", "value": "it's" }
+    ],
+    "scopedVars": {
+        "table": { "text": "cpu", "value": "cpu" },
+        "field": { "value": "usage" },
+        "host": { "text": "a + b", "value": [ "a", "b" ] },
+        "region": { "value": [ "eu" ] },
+        "dc": { "value": [ "x.1", "y" ] },
+        "rack": { "value": "$__all" }
+    }
+}`)
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT time, "usage" FROM "cpu" WHERE "host" IN ('a', 'b') AND "region" != 'eu'`+
+		` AND regexp_match("dc", '^(x\.1|y)$') IS NOT NULL AND "app" = 'it''s' AND `+timeFilter+` limit 1000`, sql)
+}
+
+func TestVariablesAllWithOr(t *testing.T) {
+	sql, err := buildWithVariables(t, `
+{
+    "table": "cpu",
+    "select": [ [ { "type": "field", "params": [ "usage" ] } ] ],
+    "tags": [
+        { "key": "host", "value": "a" },
+        { "key": "rack", "value": "$rack", "condition": "OR" }
+    ],
+    "scopedVars": { "rack": { "value": [ "$__all" ] } }
+}`)
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT time, "usage" FROM "cpu" WHERE ("host" = 'a' OR regexp_match("rack", '.*') IS NOT NULL)`+
+		` AND `+timeFilter+` limit 1000`, sql)
+}
+
+func TestVariablesRawQuery(t *testing.T) {
+	sql, err := buildWithVariables(t, `
+{
+    "rawQuery": true,
+    "queryText": "SELECT * FROM cpu WHERE host IN ($host) AND dc = '${dc:csv}' AND r ~ '[[dc:regex]]' AND app = '$app' AND $timeFilter AND $__interval = $unknown",
+    "scopedVars": {
+        "host": { "value": [ "a", "it's" ] },
+        "dc": { "value": [ "x", "y'z" ] },
+        "app": { "value": "it's" }
+    }
+}`)
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM cpu WHERE host IN ('a','it''s') AND dc = 'x,y''z' AND r ~ '(x|y''z)' AND app = 'it''s' AND `+
+		timeFilter+` AND 10 minutes = $unknown`, sql)
+
+	// Single values are inserted as is, unless sqlstring is asked for.
+	sql, err = buildWithVariables(t, `
+{
+    "rawQuery": true,
+    "queryText": "SELECT * FROM cpu WHERE host = '$host' OR host = ${host:sqlstring}",
+    "scopedVars": { "host": { "value": "a" } }
+}`)
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM cpu WHERE host = 'a' OR host = 'a'`, sql)
+}
+
+func TestVariablesErrors(t *testing.T) {
+	_, err := buildWithVariables(t, `
+{
+    "table": "$table",
+    "select": [ [ { "type": "field", "params": [ "usage" ] } ] ],
+    "scopedVars": { "table": { "value": [ "cpu", "mem" ] } }
+}`)
+	assert.EqualError(t, err, `variable "table" must have one value, got 2`)
+
+	_, err = buildWithVariables(t, `
+{
+    "table": "cpu",
+    "select": [ [ { "type": "field", "params": [ "usage" ] } ] ],
+    "tags": [ { "key": "host", "operator": "!=", "value": "$host" } ],
+    "scopedVars": { "host": { "value": "$__all" } }
+}`)
+	assert.Error(t, err)
+
+	_, err = buildWithVariables(t, `
+{
+    "rawQuery": true,
+    "queryText": "SELECT * FROM cpu WHERE host = ${host:json}",
+    "scopedVars": { "host": { "value": "a" } }
+}`)
+	assert.Error(t, err)
+}
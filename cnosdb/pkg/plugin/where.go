@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"strings"
+)
+
+// whereExpr is a node of the expression tree of a WHERE clause, it is either a condition
+// or the AND / OR of its children.
+type whereExpr struct {
+	op       string
+	cond     string
+	children []*whereExpr
+}
+
+// condExpr is a condition which is not split by AND or OR at its top level.
+func condExpr(cond string) *whereExpr {
+	return &whereExpr{cond: cond}
+}
+
+// rawExpr is a condition written by the user, it is parenthesized since it may contain anything.
+func rawExpr(cond string) *whereExpr {
+	if strings.TrimSpace(cond) == "" {
+		return nil
+	}
+	return condExpr("(" + cond + ")")
+}
+
+func andExpr(children ...*whereExpr) *whereExpr {
+	return joinExpr("AND", children)
+}
+
+func orExpr(children ...*whereExpr) *whereExpr {
+	return joinExpr("OR", children)
+}
+
+// joinExpr combines children with op, nil children are skipped and children
+// combined with the same op are flattened.
+func joinExpr(op string, children []*whereExpr) *whereExpr {
+	var flat []*whereExpr
+	for _, child := range children {
+		switch {
+		case child == nil:
+		case child.op == op:
+			flat = append(flat, child.children...)
+		default:
+			flat = append(flat, child)
+		}
+	}
+	switch len(flat) {
+	case 0:
+		return nil
+	case 1:
+		return flat[0]
+	}
+	return &whereExpr{op: op, children: flat}
+}
+
+// String renders the expression, OR is only parenthesized inside AND since AND binds tighter.
+func (e *whereExpr) String() string {
+	if e == nil {
+		return ""
+	}
+	if e.op == "" {
+		return e.cond
+	}
+	parts := make([]string, 0, len(e.children))
+	for _, child := range e.children {
+		part := child.String()
+		if e.op == "AND" && child.op == "OR" {
+			part = "(" + part + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " "+e.op+" ")
+}
+
+// tagsExpr combines tag filters by their conditions: AND binds tighter than OR,
+// so "a OR b AND c" is "a OR (b AND c)".
+func tagsExpr(tags []*TagItem) *whereExpr {
+	var groups, group []*whereExpr
+	for i, tag := range tags {
+		if i > 0 && tag.Condition == "OR" {
+			groups = append(groups, andExpr(group...))
+			group = nil
+		}
+		group = append(group, condExpr(tag.render()))
+	}
+	groups = append(groups, andExpr(group...))
+	return orExpr(groups...)
+}
@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWhereExpr(t *testing.T) {
+	a, b, c := condExpr("a"), condExpr("b"), condExpr("c")
+
+	assert.Nil(t, andExpr())
+	assert.Nil(t, andExpr(nil, orExpr()))
+	assert.Equal(t, "", andExpr(nil).String())
+	assert.Equal(t, "a", andExpr(nil, a).String())
+	assert.Equal(t, "a AND b AND c", andExpr(andExpr(a, b), c).String())
+	assert.Equal(t, "a OR b AND c", orExpr(a, andExpr(b, c)).String())
+	assert.Equal(t, "(a OR b) AND c", andExpr(orExpr(a, b), c).String())
+	assert.Equal(t, "(x OR y) AND a", andExpr(rawExpr("x OR y"), rawExpr(" "), a).String())
+}
+
+func TestTagsExpr(t *testing.T) {
+	tags := []*TagItem{
+		{Key: "a", Value: "1"},
+		{Key: "b", Value: "2", Condition: "OR"},
+		{Key: "c", Value: "3", Condition: "AND"},
+	}
+	require.NoError(t, introspectTags(tags))
+	assert.Equal(t, `"a" = '1' OR "b" = '2' AND "c" = '3'`, tagsExpr(tags).String())
+
+	// The OR of the tag filters must not swallow the conditions after them.
+	where := andExpr(tagsExpr(tags), condExpr("time >= 0"))
+	assert.Equal(t, `("a" = '1' OR "b" = '2' AND "c" = '3') AND time >= 0`, where.String())
+
+	assert.Nil(t, tagsExpr(nil))
+}
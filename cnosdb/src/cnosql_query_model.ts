@@ -5,7 +5,7 @@ import {TemplateSrv} from '@grafana/runtime';
 
 import {CnosQuery, SelectItem, TagItem} from './types';
 import {QueryPart} from './query_part';
-import {regexEscape} from './utils';
+import {quoteIdentifier, quoteString, regexEscape, splitTagValues, trimRegex} from './utils';
 import queryPart from './cnosql_query_part';
 
 export default class CnosQueryModel {
@@ -174,18 +174,37 @@ export default class CnosQueryModel {
     if (this.templateSrv && interpolate) {
       value = this.templateSrv.replace(value, this.scopedVars);
     }
-    if (operator !== '>' && operator !== '<') {
-      value = "'" + value.replace(/\\/g, '\\\\').replace(/\'/g, "\\'") + "'";
+    // Rendered like the tag filters of the backend, see tag_filter.go.
+    const key = quoteIdentifier(tag.key);
+    switch (operator) {
+      case 'IS NULL':
+      case 'IS NOT NULL':
+        return str + key + ' ' + operator;
+      case '=~':
+        return str + 'regexp_match(' + key + ', ' + quoteString(trimRegex(value)) + ') IS NOT NULL';
+      case '!~':
+        return str + 'regexp_match(' + key + ', ' + quoteString(trimRegex(value)) + ') IS NULL';
+      case 'IN':
+      case 'NOT IN':
+        value = '(' + splitTagValues(value).map(quoteString).join(', ') + ')';
+        break;
+      case '<':
+      case '<=':
+      case '>':
+      case '>=':
+        break;
+      default:
+        value = quoteString(value);
     }
 
-    return str + '"' + tag.key + '" ' + operator + ' ' + value;
+    return str + key + ' ' + operator + ' ' + value;
   }
 
   getTable(interpolate: any) {
     let table = this.target.table || 'default_table';
 
     if (!table.match('^/.*/$')) {
-      table = '"' + table + '"';
+      table = quoteIdentifier(table);
     } else if (this.templateSrv && interpolate) {
       table = this.templateSrv.replace(table, this.scopedVars, 'regex');
     }
@@ -1,6 +1,7 @@
 import {clone, map} from 'lodash';
 
-import {functionRenderer, QueryPart, QueryPartDef} from './query_part';
+import {functionRenderer, QueryPart, QueryPartDef, suffixRenderer} from './query_part';
+import {quoteIdentifier} from './utils';
 
 const index: any[] = [];
 const categories: any = {
@@ -31,14 +32,40 @@ function register(options: any) {
 const groupByTimeFunctions: any[] = [];
 
 function aliasRenderer(part: { params: string[] }, innerExpr: string) {
-  return innerExpr + ' AS ' + '"' + part.params[0] + '"';
+  return innerExpr + ' AS ' + quoteIdentifier(part.params[0]);
 }
 
 function fieldRenderer(part: { params: string[] }, innerExpr: any) {
   if (part.params[0] === '*') {
     return '*';
   }
-  return '"' + part.params[0] + '"';
+  return quoteIdentifier(part.params[0]);
+}
+
+function timeFunctionRenderer(part: any, innerExpr: string) {
+  return part.def.type + '(' + ['time', innerExpr, ...part.params].join(', ') + ')';
+}
+
+function increaseRenderer(part: any, innerExpr: string) {
+  return 'increase(time, ' + innerExpr + ' ORDER BY time)';
+}
+
+// Renders a function whose input must come from the input function, which is added if it is missing.
+function inputRenderer(input: string) {
+  return (part: any, innerExpr: string) => {
+    if (!innerExpr.startsWith(input + '(')) {
+      innerExpr = input + '(time, ' + innerExpr + ')';
+    }
+    return functionRenderer(part, innerExpr);
+  };
+}
+
+function spreadRenderer(part: any, innerExpr: string) {
+  return '(max(' + innerExpr + ') - min(' + innerExpr + '))';
+}
+
+function distinctRenderer(part: any, innerExpr: string) {
+  return 'DISTINCT ' + innerExpr;
 }
 
 export function timeRenderer(part: any, innerExpr: string) {
@@ -88,6 +115,40 @@ function replaceAggregationAddStrategy(selectParts: any[], partModel: { def: { t
   selectParts.splice(1, 0, partModel);
 }
 
+function addTransformationStrategy(selectParts: any[], partModel: any) {
+  let i;
+  // look for index to add transformation
+  for (i = 0; i < selectParts.length; i++) {
+    const part = selectParts[i];
+    if (part.def.category === categories.Math || part.def.category === categories.Aliasing) {
+      break;
+    }
+  }
+
+  selectParts.splice(i, 0, partModel);
+}
+
+function addMathStrategy(selectParts: any[], partModel: any) {
+  const partCount = selectParts.length;
+  if (partCount > 0) {
+    // if last is math, replace it
+    if (selectParts[partCount - 1].def.type === 'math') {
+      selectParts[partCount - 1] = partModel;
+      return;
+    }
+    // if next to last is math, replace it
+    if (partCount > 1 && selectParts[partCount - 2].def.type === 'math') {
+      selectParts[partCount - 2] = partModel;
+      return;
+    } else if (selectParts[partCount - 1].def.type === 'alias') {
+      // if last is alias add it before
+      selectParts.splice(partCount - 1, 0, partModel);
+      return;
+    }
+  }
+  selectParts.push(partModel);
+}
+
 function addAliasStrategy(selectParts: any[], partModel: any) {
   const partCount = selectParts.length;
   if (partCount > 0) {
@@ -182,6 +243,179 @@ register({
   renderer: functionRenderer,
 });
 
+register({
+  type: 'median',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Aggregations,
+  params: [],
+  defaultParams: [],
+  renderer: functionRenderer,
+});
+
+register({
+  type: 'mode',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Aggregations,
+  params: [],
+  defaultParams: [],
+  renderer: functionRenderer,
+});
+
+register({
+  type: 'spread',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Aggregations,
+  params: [],
+  defaultParams: [],
+  renderer: spreadRenderer,
+});
+
+register({
+  type: 'distinct',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Aggregations,
+  params: [],
+  defaultParams: [],
+  renderer: distinctRenderer,
+});
+
+// selectors
+register({
+  type: 'first',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Selectors,
+  params: [],
+  defaultParams: [],
+  renderer: timeFunctionRenderer,
+});
+
+register({
+  type: 'last',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Selectors,
+  params: [],
+  defaultParams: [],
+  renderer: timeFunctionRenderer,
+});
+
+register({
+  type: 'approx_percentile_cont',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Selectors,
+  params: [{name: 'percentile', type: 'number', options: [0.5, 0.9, 0.95, 0.99]}],
+  defaultParams: [0.95],
+  renderer: functionRenderer,
+});
+
+// transformations of InfluxQL
+register({
+  type: 'difference',
+  addStrategy: addTransformationStrategy,
+  category: categories.Transformations,
+  params: [{name: 'reset', type: 'string', optional: true, options: ['counter', 'null']}],
+  defaultParams: [],
+  renderer: functionRenderer,
+});
+
+register({
+  type: 'cumulative_sum',
+  addStrategy: addTransformationStrategy,
+  category: categories.Transformations,
+  params: [{name: 'period', type: 'time', optional: true, options: ['1 hour', '1 day', '7 days']}],
+  defaultParams: [],
+  renderer: functionRenderer,
+});
+
+register({
+  type: 'moving_average',
+  addStrategy: addTransformationStrategy,
+  category: categories.Transformations,
+  params: [{name: 'window', type: 'int', options: [5, 10, 20, 30, 40]}],
+  defaultParams: [10],
+  renderer: functionRenderer,
+});
+
+for (const type of ['derivative', 'non_negative_derivative']) {
+  register({
+    type: type,
+    addStrategy: addTransformationStrategy,
+    category: categories.Transformations,
+    params: [{name: 'unit', type: 'time', optional: true, options: ['1s', '10s', '1m', '5m', '10m', '15m', '1h']}],
+    defaultParams: ['1s'],
+    renderer: functionRenderer,
+  });
+}
+
+// time series functions
+register({
+  type: 'increase',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Transformations,
+  params: [],
+  defaultParams: [],
+  renderer: increaseRenderer,
+});
+
+register({
+  type: 'gauge_agg',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Aggregations,
+  params: [],
+  defaultParams: [],
+  renderer: timeFunctionRenderer,
+});
+
+for (const type of ['delta', 'time_delta', 'rate']) {
+  register({
+    type: type,
+    addStrategy: addTransformationStrategy,
+    category: categories.Transformations,
+    params: [],
+    defaultParams: [],
+    renderer: inputRenderer('gauge_agg'),
+  });
+}
+
+register({
+  type: 'state_agg',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Aggregations,
+  params: [],
+  defaultParams: [],
+  renderer: timeFunctionRenderer,
+});
+
+register({
+  type: 'duration_in',
+  addStrategy: addTransformationStrategy,
+  category: categories.Transformations,
+  params: [
+    {name: 'state', type: 'string', quote: 'single'},
+    {name: 'start', type: 'string', quote: 'single', optional: true},
+    {name: 'interval', type: 'time', optional: true},
+  ],
+  defaultParams: ['running'],
+  renderer: inputRenderer('state_agg'),
+});
+
+register({
+  type: 'sample',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Selectors,
+  params: [{name: 'n', type: 'int', options: [1, 5, 10, 100]}],
+  defaultParams: [10],
+  renderer: functionRenderer,
+});
+
+register({
+  type: 'asap_smooth',
+  addStrategy: replaceAggregationAddStrategy,
+  category: categories.Transformations,
+  params: [{name: 'resolution', type: 'int', options: [10, 100, 1000]}],
+  defaultParams: [100],
+  renderer: timeFunctionRenderer,
+});
+
 // transformations
 //
 register({
@@ -192,7 +426,13 @@ register({
       name: 'interval',
       type: 'time',
       // TODO: Use simplified time '1s', '10s', '1m'...
-      options: ['1 second', '10 seconds', '1 minute', '5 minutes', '10 minutes', '15 minutes', '1 hour'],
+      options: ['$__interval', '1 second', '10 seconds', '1 minute', '5 minutes', '10 minutes', '15 minutes', '1 hour'],
+    },
+    {
+      name: 'offset',
+      type: 'time',
+      optional: true,
+      options: ['15 minutes', '30 minutes', '1 hour'],
     },
   ],
   defaultParams: ['1 minute'],
@@ -223,6 +463,16 @@ register({
   renderer: fieldRenderer,
 });
 
+register({
+  type: 'math',
+  addStrategy: addMathStrategy,
+  category: categories.Math,
+  params: [{name: 'expr', type: 'string'}],
+  defaultParams: [' / 100'],
+  renderMode: 'suffix',
+  renderer: suffixRenderer,
+});
+
 register({
   type: 'alias',
   addStrategy: addAliasStrategy,
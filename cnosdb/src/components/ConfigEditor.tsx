@@ -4,6 +4,8 @@ import {Base64} from 'js-base64';
 
 import {
   DataSourcePluginOptionsEditorProps,
+  onUpdateDatasourceJsonDataOption,
+  onUpdateDatasourceJsonDataOptionChecked,
   onUpdateDatasourceOption,
   updateDatasourcePluginResetOption,
 } from '@grafana/data';
@@ -11,7 +13,7 @@ import {InlineFormLabel, LegacyForms, LegacyInputStatus} from '@grafana/ui';
 
 import {CnosDataSourceOptions, CnosSecureJsonData} from '../types';
 
-const {Input, SecretFormField} = LegacyForms;
+const {Input, SecretFormField, Switch} = LegacyForms;
 
 type ConfigInputProps = {
   label: string;
@@ -126,6 +128,107 @@ export class ConfigEditor extends PureComponent<Props, State> {
             </div>
           </div>
         </div>
+        <div className="gf-form-group">
+          <div>
+            <h3 className="page-heading">Query Options</h3>
+          </div>
+          <ConfigInput
+            label="Max concurrent queries"
+            htmlPrefix={`${this.htmlPrefix}-max-concurrent-queries`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'maxConcurrentQueries')}
+            value={options.jsonData.maxConcurrentQueries?.toString() || ''}
+          />
+          <ConfigInput
+            label="Query timeout"
+            htmlPrefix={`${this.htmlPrefix}-query-timeout`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'queryTimeout')}
+            value={options.jsonData.queryTimeout || ''}
+          />
+          <ConfigInput
+            label="Max response bytes"
+            htmlPrefix={`${this.htmlPrefix}-max-response-size`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'maxResponseSize')}
+            value={options.jsonData.maxResponseSize?.toString() || ''}
+          />
+          <ConfigInput
+            label="Time zone"
+            htmlPrefix={`${this.htmlPrefix}-timezone`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'timezone')}
+            value={options.jsonData.timezone || ''}
+          />
+          <ConfigInput
+            label="Min time interval"
+            htmlPrefix={`${this.htmlPrefix}-time-interval`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'timeInterval')}
+            value={options.jsonData.timeInterval || ''}
+          />
+          <ConfigInput
+            label="Cache TTL"
+            htmlPrefix={`${this.htmlPrefix}-cache-ttl`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'cacheTTL')}
+            value={options.jsonData.cacheTTL || ''}
+          />
+          <ConfigInput
+            label="Max cache bytes"
+            htmlPrefix={`${this.htmlPrefix}-cache-max-size`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'cacheMaxSize')}
+            value={options.jsonData.cacheMaxSize?.toString() || ''}
+          />
+          <div className="gf-form-inline">
+            <Switch
+              label="Incremental queries"
+              labelClass="width-10"
+              tooltip="Fetch only the new rows of queries grouped by time when the dashboard is refreshed"
+              checked={!!options.jsonData.incrementalQueries}
+              onChange={onUpdateDatasourceJsonDataOptionChecked(this.props, 'incrementalQueries')}
+            />
+          </div>
+          <ConfigInput
+            label="Max incremental bytes"
+            htmlPrefix={`${this.htmlPrefix}-incremental-max-size`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'incrementalMaxSize')}
+            value={options.jsonData.incrementalMaxSize?.toString() || ''}
+          />
+          <ConfigInput
+            label="Incremental TTL"
+            htmlPrefix={`${this.htmlPrefix}-incremental-ttl`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'incrementalTTL')}
+            value={options.jsonData.incrementalTTL || ''}
+          />
+          <ConfigInput
+            label="Late rows grace period"
+            htmlPrefix={`${this.htmlPrefix}-incremental-grace-period`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'incrementalGracePeriod')}
+            value={options.jsonData.incrementalGracePeriod || ''}
+          />
+          <ConfigInput
+            label="Disk cache directory"
+            htmlPrefix={`${this.htmlPrefix}-disk-cache-dir`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'diskCacheDir')}
+            value={options.jsonData.diskCacheDir || ''}
+          />
+          <ConfigInput
+            label="Max disk cache bytes"
+            htmlPrefix={`${this.htmlPrefix}-disk-cache-max-size`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'diskCacheMaxSize')}
+            value={options.jsonData.diskCacheMaxSize?.toString() || ''}
+          />
+          <ConfigInput
+            label="Immutable after"
+            htmlPrefix={`${this.htmlPrefix}-immutable-after`}
+            onChange={onUpdateDatasourceJsonDataOption(this.props, 'immutableAfter')}
+            value={options.jsonData.immutableAfter || ''}
+          />
+          <div className="gf-form-inline">
+            <Switch
+              label="Validate queries"
+              labelClass="width-10"
+              tooltip="Check the tables, fields and tags of queries against the schema of the database before sending them"
+              checked={!!options.jsonData.validateSchema}
+              onChange={onUpdateDatasourceJsonDataOptionChecked(this.props, 'validateSchema')}
+            />
+          </div>
+        </div>
       </>
     );
   }
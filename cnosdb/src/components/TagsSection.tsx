@@ -6,8 +6,37 @@ import {adjustOperatorIfNeeded, getCondition, getOperator, toSelectableValue} fr
 import {Seg} from "./Seg";
 import {SelectableValue} from "@grafana/data";
 
-type KnownOperator = '=' | '!=' | '<' | '>';
-const knownOperators: KnownOperator[] = ['=', '!=', '<', '>'];
+type KnownOperator =
+  | '='
+  | '!='
+  | '<'
+  | '<='
+  | '>'
+  | '>='
+  | '=~'
+  | '!~'
+  | 'IN'
+  | 'NOT IN'
+  | 'LIKE'
+  | 'NOT LIKE'
+  | 'IS NULL'
+  | 'IS NOT NULL';
+const knownOperators: KnownOperator[] = [
+  '=',
+  '!=',
+  '<',
+  '<=',
+  '>',
+  '>=',
+  '=~',
+  '!~',
+  'IN',
+  'NOT IN',
+  'LIKE',
+  'NOT LIKE',
+  'IS NULL',
+  'IS NOT NULL',
+];
 
 type KnownCondition = 'AND' | 'OR';
 const knownConditions: KnownCondition[] = ['AND', 'OR'];
@@ -22,10 +51,10 @@ type Props = {
   tags: TagItem[];
   onChange: (tags: TagItem[]) => void;
   getTagKeyOptions: () => Promise<string[]>;
+  getTagValueOptions: (key: string, filter: string) => Promise<string[]>;
 };
 
-// TODO Use <select/> to get Tag Value filters.
-export const TagsSection = ({tags, onChange, getTagKeyOptions}: Props): JSX.Element => {
+export const TagsSection = ({tags, onChange, getTagKeyOptions, getTagValueOptions}: Props): JSX.Element => {
   const onTagChange = (newTag: TagItem, index: number) => {
     const newTags = tags.map((tag, i) => {
       return index === i ? newTag : tag;
@@ -72,6 +101,7 @@ export const TagsSection = ({tags, onChange, getTagKeyOptions}: Props): JSX.Elem
             onTagRemove(i);
           }}
           getTagKeyOptions={getTagKeyOptions}
+          getTagValueOptions={getTagValueOptions}
         />
       ))}
       <AddButton
@@ -92,9 +122,10 @@ type TagProps = {
   onRemove: () => void;
   onChange: (tag: TagItem) => void;
   getTagKeyOptions: () => Promise<string[]>;
+  getTagValueOptions: (key: string, filter: string) => Promise<string[]>;
 };
 
-const Tag = ({ tag, isFirst, onRemove, onChange, getTagKeyOptions }: TagProps): JSX.Element => {
+const Tag = ({ tag, isFirst, onRemove, onChange, getTagKeyOptions, getTagValueOptions }: TagProps): JSX.Element => {
   const operator = getOperator(tag);
   const condition = getCondition(tag, isFirst);
 
@@ -107,6 +138,15 @@ const Tag = ({ tag, isFirst, onRemove, onChange, getTagKeyOptions }: TagProps):
       .then((tags) => [{ label: '-- remove tag filter --', value: undefined }, ...tags.map(toSelectableValue)]);
   };
 
+  const getTagValueSegmentOptions = (filter: string) => {
+    return getTagValueOptions(tag.key, filter)
+      .catch((err) => {
+        console.error(err);
+        return [];
+      })
+      .then((values) => values.map(toSelectableValue));
+  };
+
   return (
     <div className="gf-form">
       {condition != null && (
@@ -141,6 +181,8 @@ const Tag = ({ tag, isFirst, onRemove, onChange, getTagKeyOptions }: TagProps):
       <Seg
         allowCustomValue
         value={tag.value}
+        loadOptions={getTagValueSegmentOptions}
+        filterByLoadOptions
         onChange={(v) => {
           const value = v.value ?? '';
           onChange({ ...tag, value, operator: adjustOperatorIfNeeded(operator, value) });
@@ -16,7 +16,7 @@ import {
   removeGroupByPart,
   removeSelectPart,
 } from '../query_utils';
-import {getAllTables, getFieldNamesFromTable, getTagKeysFromTable} from '../meta_query';
+import {getAllTables, getFieldNamesFromTable, getTagKeysFromTable, getTagValues} from '../meta_query';
 import {getNewGroupByPartOptions, getNewSelectPartOptions, makePartList} from './part_list_utils';
 import {FromSection} from './FromSection';
 import {TagsSection} from './TagsSection';
@@ -135,6 +135,9 @@ export const VisualQueryEditor = (props: Props): JSX.Element => {
           tags={query.tags ?? []}
           onChange={handleTagsSectionChange}
           getTagKeyOptions={getTagKeys}
+          getTagValueOptions={(key, filter) =>
+            withTemplateVariableOptions(getTagValues(table, key, filter === '' ? undefined : filter, datasource))
+          }
         />
       </SegmentSection>
       {selectLists.map((sel, index) => (
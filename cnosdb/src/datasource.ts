@@ -1,11 +1,11 @@
 import {lastValueFrom, of} from 'rxjs';
 import {map} from 'rxjs/operators';
 
-import {DataSourceInstanceSettings, MetricFindValue} from "@grafana/data";
+import {DataSourceInstanceSettings, MetricFindValue, ScopedVars} from "@grafana/data";
 import {DataSourceWithBackend, getBackendSrv, getTemplateSrv, TemplateSrv} from '@grafana/runtime';
 import {BackendSrvRequest} from "@grafana/runtime/services/backendSrv";
 
-import {CnosDataSourceOptions, CnosQuery} from './types';
+import {CnosDataSourceOptions, CnosQuery, ScopedVarValue} from './types';
 import {each, findIndex, zip} from "lodash";
 import {DataFrameJSON} from "@grafana/data/dataframe/DataFrameJSON";
 
@@ -20,6 +20,28 @@ export class CnosDataSource extends DataSourceWithBackend<CnosQuery, CnosDataSou
     this.datasourceUid = instanceSettings.uid;
   }
 
+  // The variables are expanded by the backend, which escapes their values in the SQL,
+  // so the query is sent with the values of the variables and the ad-hoc filters.
+  applyTemplateVariables(query: CnosQuery, scopedVars: ScopedVars): Record<string, any> {
+    const vars: Record<string, ScopedVarValue> = {};
+    for (const variable of this.templateSrv.getVariables() as any[]) {
+      if (variable.current) {
+        vars[variable.name] = {text: variable.current.text, value: variable.current.value};
+      }
+    }
+    for (const [name, variable] of Object.entries(scopedVars)) {
+      if (variable) {
+        vars[name] = {text: variable.text, value: variable.value};
+      }
+    }
+    const adhocFilters = (this.templateSrv as any).getAdhocFilters?.(this.name) ?? [];
+    return {
+      ...query,
+      scopedVars: vars,
+      adhocFilters: [...(query.adhocFilters ?? []), ...adhocFilters],
+    };
+  }
+
   async metricFindQuery(query: string, options?: any): Promise<MetricFindValue[]> {
     const interpolated = this.templateSrv.replace(query, undefined, 'regex');
     return lastValueFrom(this._fetchMetric(interpolated)).then((results) => {
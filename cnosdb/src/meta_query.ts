@@ -1,12 +1,25 @@
 import {CnosDataSource} from './datasource';
-import {TagItem} from "./types";
+import {Column, TagItem} from "./types";
 
 export async function getAllTables(
   filter: string | undefined,
   datasource: CnosDataSource
 ): Promise<string[]> {
-  const data = await datasource.metricFindQuery('SHOW TABLES', datasource);
-  return data.map((item) => item.text);
+  return datasource.getResource('tables');
+}
+
+export async function getAllDatabases(datasource: CnosDataSource): Promise<string[]> {
+  return datasource.getResource('databases');
+}
+
+export async function getColumnsFromTable(
+  table: string | undefined,
+  datasource: CnosDataSource
+): Promise<Column[]> {
+  if (table === undefined || table === '') {
+    return [];
+  }
+  return datasource.getResource(`tables/${encodeURIComponent(table)}/columns`);
 }
 
 export async function getTagKeysFromTable(
@@ -14,14 +27,27 @@ export async function getTagKeysFromTable(
   tags: TagItem[],
   datasource: CnosDataSource
 ): Promise<string[]> {
-  const data = await datasource.metricFindQuery('-- tag;\nDESCRIBE TABLE ' + table, datasource);
-  return data.map((item) => item.text);
+  const columns = await getColumnsFromTable(table, datasource);
+  return columns.filter((c) => c.kind === 'tag').map((c) => c.name);
 }
 
 export async function getFieldNamesFromTable(
   table: string | undefined,
   datasource: CnosDataSource
 ): Promise<string[]> {
-  const data = await datasource.metricFindQuery('-- field;\nDESCRIBE TABLE ' + table, datasource);
-  return data.map((item) => item.text);
+  const columns = await getColumnsFromTable(table, datasource);
+  return columns.filter((c) => c.kind === 'field').map((c) => c.name);
+}
+
+export async function getTagValues(
+  table: string | undefined,
+  key: string,
+  filter: string | undefined,
+  datasource: CnosDataSource
+): Promise<string[]> {
+  if (table === undefined || table === '' || key === '') {
+    return [];
+  }
+  const path = `tables/${encodeURIComponent(table)}/tags/${encodeURIComponent(key)}/values`;
+  return datasource.getResource(path, filter ? {filter} : undefined);
 }
@@ -5,6 +5,7 @@
 import {clone, each, map} from 'lodash';
 
 import {SelectItem} from './types';
+import {quoteIdentifier, quoteString} from './utils';
 
 export class QueryPartDef {
   type: string;
@@ -99,9 +100,9 @@ export function functionRenderer(part: any, innerExpr: string) {
       }
     }
     if (paramType.quote === 'single') {
-      return "'" + value + "'";
+      return quoteString(value);
     } else if (paramType.quote === 'double') {
-      return '"' + value + '"';
+      return quoteIdentifier(value);
     }
 
     return value;
@@ -122,5 +123,5 @@ export function identityRenderer(part: QueryPart, innerExpr: string) {
 }
 
 export function quotedIdentityRenderer(part: QueryPart, innerExpr: string) {
-  return '"' + part.params[0] + '"';
+  return quoteIdentifier(part.params[0]);
 }
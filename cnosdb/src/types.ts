@@ -7,6 +7,21 @@ export interface CnosDataSourceOptions extends DataSourceJsonData {
   url?: string;
   database?: string;
   user?: string;
+  maxConcurrentQueries?: string | number;
+  queryTimeout?: string;
+  maxResponseSize?: string | number;
+  timezone?: string;
+  timeInterval?: string;
+  cacheTTL?: string;
+  cacheMaxSize?: string | number;
+  incrementalQueries?: boolean;
+  incrementalMaxSize?: string | number;
+  incrementalTTL?: string;
+  incrementalGracePeriod?: string;
+  diskCacheDir?: string;
+  diskCacheMaxSize?: string | number;
+  immutableAfter?: string;
+  validateSchema?: boolean;
 }
 
 /**
@@ -17,17 +32,34 @@ export interface CnosSecureJsonData {
   password?: string;
 }
 
+/**
+ * A column of a table, returned by the columns resource of the backend
+ */
+export interface Column {
+  name: string;
+  kind: 'time' | 'tag' | 'field';
+  type: string;
+}
+
+export interface ScopedVarValue {
+  text?: any;
+  value: any;
+}
+
 export interface CnosQuery extends DataQuery {
   table?: string;
   select: SelectItem[][];
   tags?: TagItem[];
   rawTagsExpr?: string;
+  adhocFilters?: TagItem[];
+  scopedVars?: Record<string, ScopedVarValue>;
   groupBy?: SelectItem[];
   interval?: string;
   fill?: string;
   orderByTime?: string;
   limit?: string | number;
   tz?: string;
+  timeout?: string;
 
   rawQuery?: boolean;
   queryText?: string;
@@ -13,6 +13,64 @@ export function regexEscape(value: string) {
   return value.replace(/[\\^$*+?.()|[\]{}\/]/g, '\\$&');
 }
 
+// Quotes an identifier the way the backend does, embedded double quotes are doubled.
+export function quoteIdentifier(name: string): string {
+  return '"' + name.replace(/"/g, '""') + '"';
+}
+
+// Quotes a string literal the way the backend does, embedded single quotes are doubled.
+export function quoteString(value: string): string {
+  return "'" + value.replace(/'/g, "''") + "'";
+}
+
+// Splits the values of IN filters, formatted as "{a,b}" or "(a, b)", with the rules of splitTagValues
+// of the backend: a value quoted with ' or " may contain commas, its quote is escaped by doubling it.
+export function splitTagValues(value: string): string[] {
+  value = value.trim();
+  if (value.length >= 2 && ((value[0] === '{' && value.endsWith('}')) || (value[0] === '(' && value.endsWith(')')))) {
+    value = value.slice(1, -1);
+  }
+  const values: string[] = [];
+  let current = '';
+  let quote = '';
+  let quoted = false;
+  const flush = () => {
+    if (quoted) {
+      values.push(current);
+    } else if (current.trim() !== '') {
+      values.push(current.trim());
+    }
+    current = '';
+    quoted = false;
+  };
+  for (let i = 0; i < value.length; i++) {
+    const c = value[i];
+    if (quote !== '' && c === quote && value[i + 1] === quote) {
+      current += c;
+      i++;
+    } else if (quote !== '' && c === quote) {
+      quote = '';
+    } else if (quote !== '') {
+      current += c;
+    } else if (c === ',') {
+      flush();
+    } else if ((c === "'" || c === '"') && !quoted && current.trim() === '') {
+      current = '';
+      quote = c;
+      quoted = true;
+    } else if (!(quoted && c === ' ')) {
+      current += c;
+    }
+  }
+  flush();
+  return values;
+}
+
+// Removes the slashes around a regex written as "/pattern/".
+export function trimRegex(value: string): string {
+  return isRegex(value) ? value.slice(1, -1) : value;
+}
+
 export function unwrap<T>(value: T | null | undefined): T {
   if (value == null) {
     throw new Error('value must not be nullish');